@@ -2,18 +2,17 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io/fs"
 	"log"
 	"os"
-	"path"
 	"path/filepath"
-	"strings"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/printeers/trek/internal/configuration"
 	internalpostgres "github.com/printeers/trek/internal/postgres"
+	"github.com/printeers/trek/internal/psql"
 
 	// needed driver.
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
@@ -27,13 +26,12 @@ import (
 //nolint:gocognit,cyclop
 func NewApplyCommand() *cobra.Command {
 	var (
-		postgresHost     string
-		postgresPort     int
-		postgresUser     string
-		postgresPassword string
-		postgresSSLMode  string
-		resetDatabase    bool
-		insertTestData   bool
+		postgresFlags  internal.PostgresFlags
+		resetDatabase  bool
+		insertTestData bool
+		toVersion      uint
+		dryRun         bool
+		dryRunOutput   string
 	)
 
 	applyCmd := &cobra.Command{
@@ -42,8 +40,10 @@ func NewApplyCommand() *cobra.Command {
 		PersistentPreRun: func(cmd *cobra.Command, _ []string) {
 			internal.InitializeFlags(cmd)
 		},
-		RunE: func(_ *cobra.Command, _ []string) error {
+		RunE: func(cmd *cobra.Command, _ []string) error {
 			ctx := context.Background()
+			// Version 0 is a valid target, it reverts all migrations
+			toVersionSet := cmd.Flags().Changed("to-version")
 
 			wd, err := os.Getwd()
 			if err != nil {
@@ -55,23 +55,24 @@ func NewApplyCommand() *cobra.Command {
 				return fmt.Errorf("failed to read config: %w", err)
 			}
 
-			conn, err := pgx.Connect(ctx, fmt.Sprintf(
-				"postgres://%s:%s@%s:%d/%s?sslmode=%s",
-				postgresUser,
-				postgresPassword,
-				postgresHost,
-				postgresPort,
-				"postgres", // We need to connect to the default database in order to drop and create the actual database
-				postgresSSLMode,
-			))
+			if dryRun {
+				return runApplyDryRun(
+					ctx, config, wd, &postgresFlags, resetDatabase, insertTestData, toVersion, toVersionSet, dryRunOutput)
+			}
+
+			// We need to connect to the default database in order to drop and create the actual database
+			conn, err := pgx.Connect(ctx, postgresFlags.DSN("postgres"))
 			if err != nil {
 				return fmt.Errorf("failed to connect to database: %w", err)
 			}
 
+			dsn := postgresFlags.DSN(config.DatabaseName)
+			hookOptions := &internal.HookOptions{DSN: dsn}
+
 			if resetDatabase {
 				log.Println("Resetting database")
 
-				err = internal.RunHook(ctx, wd, "apply-reset-pre", nil)
+				err = internal.RunHook(ctx, config, wd, "apply-reset-pre", hookOptions)
 				if err != nil {
 					return fmt.Errorf("failed to run hook: %w", err)
 				}
@@ -113,74 +114,82 @@ func NewApplyCommand() *cobra.Command {
 				return fmt.Errorf("failed to close database connection: %w", err)
 			}
 
-			dsn := fmt.Sprintf(
-				"postgres://%s:%s@%s:%d/%s?sslmode=%s",
-				postgresUser,
-				postgresPassword,
-				postgresHost,
-				postgresPort,
-				config.DatabaseName,
-				postgresSSLMode,
-			)
+			err = internal.RunHook(ctx, config, wd, "apply-pre", hookOptions)
+			if err != nil {
+				return fmt.Errorf("failed to run hook: %w", err)
+			}
 
 			migrationsDir, err := internal.GetMigrationsDir(wd)
 			if err != nil {
 				return fmt.Errorf("failed to get migrations directory: %w", err)
 			}
 
-			m, err := migrate.New(fmt.Sprintf("file://%s", migrationsDir), dsn)
+			migrationFiles, err := internal.FindMigrations(migrationsDir, true)
 			if err != nil {
-				return fmt.Errorf("failed to initialize go-migrate: %w", err)
+				return fmt.Errorf("failed to read migrations: %w", err)
 			}
 
-			if resetDatabase || !databaseExists {
-				var migrationFiles []string
-				migrationFiles, err = internal.FindMigrations(migrationsDir, true)
+			targetMigrationFiles := migrationFiles
+			if toVersionSet {
+				targetMigrationFiles, err = migrationsUpToTarget(migrationFiles, toVersion)
 				if err != nil {
-					return fmt.Errorf("failed to read migrations: %w", err)
+					return err
 				}
+			}
+
+			m, err := internal.NewMigrator(migrationsDir, dsn, config.VersionOffset)
+			if err != nil {
+				return fmt.Errorf("failed to initialize migrator: %w", err)
+			}
+			defer m.Close() //nolint:errcheck
+			m.EnableHooks(ctx, config, wd)
+
+			if resetDatabase || !databaseExists {
+				for _, file := range targetMigrationFiles {
+					var version uint
+					version, err = internal.GetMigrationVersion(file)
+					if err != nil {
+						return err //nolint:wrapcheck
+					}
 
-				for index, file := range migrationFiles {
 					log.Printf("Applying migration %q\n", file)
-					err = m.Steps(1)
+					err = m.Step(internal.MigrationStep{File: file, Version: version, Up: true})
 					if errors.Is(err, migrate.ErrNoChange) {
 						log.Println("No changes!")
 					} else if err != nil {
 						return fmt.Errorf("failed to apply migration %q: %w", file, err)
 					}
 					if insertTestData {
-						err = filepath.Walk(filepath.Join(wd, "testdata"), func(p string, _ fs.FileInfo, err error) error {
-							if err != nil {
-								return err
-							}
-
-							if strings.HasPrefix(path.Base(p), fmt.Sprintf("%03d", index+1)) {
-								log.Printf("Inserting testdata %q\n", path.Base(p))
+						var testdataFiles []string
+						testdataFiles, err = internal.FindTestdata(wd, version)
+						if err != nil {
+							return fmt.Errorf("failed to find testdata: %w", err)
+						}
 
-								// We have to use psql, because users might use commands like "\copy"
-								// which don't work by directly connecting to the database
-								err := internalpostgres.PsqlFile(ctx, dsn, p)
-								if err != nil {
-									return fmt.Errorf("failed to insert testdata: %w", err)
-								}
+						for _, p := range testdataFiles {
+							log.Printf("Inserting testdata %q\n", filepath.Base(p))
 
-								return nil
+							// We have to use psql, because users might use commands like "\copy"
+							// which don't work by directly connecting to the database
+							err = psql.RunFile(ctx, dsn, p, nil)
+							if err != nil {
+								return fmt.Errorf("failed to insert testdata: %w", err)
 							}
-
-							return nil
-						})
-						if err != nil {
-							return fmt.Errorf("failed to run testdata: %w", err)
 						}
 					}
 				}
 
-				err = internal.RunHook(ctx, wd, "apply-reset-post", nil)
+				err = internal.RunHook(ctx, config, wd, "apply-reset-post", hookOptions)
 				if err != nil {
 					return fmt.Errorf("failed to run hook: %w", err)
 				}
+			} else if toVersionSet {
+				err = migrateToVersion(m, migrationsDir, migrationFiles, toVersion)
+				if err != nil {
+					return err
+				}
 			} else {
-				err = m.Up()
+				err = m.Up(migrationFiles)
 				if errors.Is(err, migrate.ErrNoChange) {
 					log.Println("No changes!")
 				} else if err != nil {
@@ -205,23 +214,278 @@ func NewApplyCommand() *cobra.Command {
 				return fmt.Errorf("failed to close database connection: %w", err)
 			}
 
+			err = internal.RunHook(ctx, config, wd, "apply-post", hookOptions)
+			if err != nil {
+				return fmt.Errorf("failed to run hook: %w", err)
+			}
+
 			log.Println("Successfully migrated database")
 
 			return nil
 		},
 	}
 
-	applyCmd.Flags().StringVar(&postgresHost, "postgres-host", "", "Host of the PostgreSQL database")
-	applyCmd.Flags().IntVar(&postgresPort, "postgres-port", 0, "Port of the PostgreSQL database")
-	applyCmd.Flags().StringVar(&postgresUser, "postgres-user", "", "User of the PostgreSQL database")
-	applyCmd.Flags().StringVar(&postgresPassword, "postgres-password", "", "Password of the PostgreSQL database")
-	applyCmd.Flags().StringVar(&postgresSSLMode, "postgres-sslmode", "disable", "SSL Mode of the PostgreSQL database")
+	postgresFlags.Register(applyCmd)
 	applyCmd.Flags().BoolVar(&resetDatabase, "reset-database", false, "Reset the database before applying migrations")
 	applyCmd.Flags().BoolVar(&insertTestData, "insert-test-data", false, "Insert the testdata of each migration after the individual migrations has been applied") //nolint:lll
-	internal.MarkFlagRequired(applyCmd, "postgres-host")
-	internal.MarkFlagRequired(applyCmd, "postgres-port")
-	internal.MarkFlagRequired(applyCmd, "postgres-user")
-	internal.MarkFlagRequired(applyCmd, "postgres-password")
+	applyCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Print what would be applied without changing the database")
+	applyCmd.Flags().StringVar(&dryRunOutput, "dry-run-output", "", "Write the dry run plan as JSON to the given file. Only works with --dry-run") //nolint:lll
+	applyCmd.Flags().UintVar(&toVersion, "to-version", 0, "Migrate up or down to the given version instead of the latest version") //nolint:lll
 
 	return applyCmd
 }
+
+// migrationsUpToTarget returns the sorted up migrations up to and including
+// the target version. Target version 0 returns none.
+func migrationsUpToTarget(migrationFiles []string, toVersion uint) ([]string, error) {
+	if toVersion == 0 {
+		return nil, nil
+	}
+
+	return internal.MigrationsUpTo(migrationFiles, toVersion) //nolint:wrapcheck
+}
+
+// migrateToVersion migrates the database up or down to the given version. All
+// files that will run are logged before anything is executed.
+func migrateToVersion(m *internal.Migrator, migrationsDir string, migrationFiles []string, toVersion uint) error {
+	currentVersion, dirty, err := m.Version()
+	if err != nil {
+		return fmt.Errorf("failed to get database version: %w", err)
+	}
+	if dirty {
+		//nolint:err113
+		return fmt.Errorf("database version %d is dirty, fix it manually before migrating", currentVersion)
+	}
+
+	steps, err := internal.PlanMigrationSteps(migrationsDir, migrationFiles, currentVersion, toVersion)
+	if err != nil {
+		return fmt.Errorf("failed to plan migration from version %d to %d: %w", currentVersion, toVersion, err)
+	}
+
+	if len(steps) == 0 {
+		log.Println("No changes!")
+
+		return nil
+	}
+
+	log.Printf("Migrating from version %d to version %d\n", currentVersion, toVersion)
+	for _, step := range steps {
+		if step.Up {
+			log.Printf("Will apply migration %q\n", step.File)
+		} else {
+			log.Printf("Will revert migration %q\n", step.File)
+		}
+	}
+
+	err = m.Migrate(migrationFiles, toVersion)
+	if err != nil {
+		return fmt.Errorf("failed to migrate to version %d: %w", toVersion, err)
+	}
+
+	return nil
+}
+
+//nolint:tagliatelle
+type applyPlan struct {
+	Database       string               `json:"database"`
+	CreateDatabase bool                 `json:"create_database"`
+	ResetDatabase  bool                 `json:"reset_database"`
+	CurrentVersion *uint                `json:"current_version"`
+	TargetVersion  uint                 `json:"target_version"`
+	MissingRoles   []string             `json:"missing_roles"`
+	Migrations     []applyPlanMigration `json:"migrations"`
+	Testdata       []string             `json:"testdata"`
+}
+
+type applyPlanMigration struct {
+	File      string `json:"file"`
+	Version   uint   `json:"version"`
+	Direction string `json:"direction"`
+	SQL       string `json:"sql"`
+}
+
+// runApplyDryRun works out what apply would do with the same flags and prints
+// it. The database is only accessed through read-only connections.
+//
+//nolint:gocognit,cyclop
+func runApplyDryRun(
+	ctx context.Context,
+	config *configuration.Config,
+	wd string,
+	postgresFlags *internal.PostgresFlags,
+	resetDatabase bool,
+	insertTestData bool,
+	toVersion uint,
+	toVersionSet bool,
+	output string,
+) error {
+	migrationsDir, err := internal.GetMigrationsDir(wd)
+	if err != nil {
+		return fmt.Errorf("failed to get migrations directory: %w", err)
+	}
+
+	migrationFiles, err := internal.FindMigrations(migrationsDir, true)
+	if err != nil {
+		return fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	_, err = migrationsUpToTarget(migrationFiles, toVersion)
+	if err != nil {
+		return err
+	}
+	if !toVersionSet {
+		toVersion, err = internal.LatestMigrationVersion(migrationFiles)
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+	}
+
+	plan := &applyPlan{
+		Database:      config.DatabaseName,
+		ResetDatabase: resetDatabase,
+		TargetVersion: toVersion,
+		MissingRoles:  []string{},
+		Migrations:    []applyPlanMigration{},
+		Testdata:      []string{},
+	}
+
+	conn, err := pgx.Connect(ctx, postgresFlags.DSN("postgres")+"&default_transaction_read_only=on")
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	databaseExists, err := internalpostgres.CheckDatabaseExists(ctx, conn, config.DatabaseName)
+	if err != nil {
+		return fmt.Errorf("failed to check if database exists: %w", err)
+	}
+	plan.CreateDatabase = resetDatabase || !databaseExists
+
+	for _, role := range config.Roles {
+		var roleExists bool
+		roleExists, err = internalpostgres.CheckRoleExists(ctx, conn, role.Name)
+		if err != nil {
+			return fmt.Errorf("failed to check if role exists: %w", err)
+		}
+		if !roleExists {
+			plan.MissingRoles = append(plan.MissingRoles, role.Name)
+		}
+	}
+
+	var currentVersion uint
+	if databaseExists && !resetDatabase {
+		var databaseConn *pgx.Conn
+		databaseConn, err = pgx.Connect(ctx, postgresFlags.DSN(config.DatabaseName)+"&default_transaction_read_only=on")
+		if err != nil {
+			return fmt.Errorf("failed to connect to database: %w", err)
+		}
+		defer databaseConn.Close(ctx)
+
+		var dirty, found bool
+		currentVersion, dirty, found, err = internalpostgres.GetSchemaMigrationsVersion(ctx, databaseConn)
+		if err != nil {
+			return fmt.Errorf("failed to get database version: %w", err)
+		}
+		if dirty {
+			//nolint:err113
+			return fmt.Errorf("database version %d is dirty, fix it manually before migrating", currentVersion)
+		}
+		currentVersion, err = internal.FileVersion(currentVersion, config.VersionOffset)
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+		if found {
+			plan.CurrentVersion = &currentVersion
+		}
+	}
+
+	steps, err := internal.PlanMigrationSteps(migrationsDir, migrationFiles, currentVersion, toVersion)
+	if err != nil {
+		return fmt.Errorf("failed to plan migration from version %d to %d: %w", currentVersion, toVersion, err)
+	}
+
+	for _, step := range steps {
+		var content []byte
+		content, err = os.ReadFile(filepath.Join(migrationsDir, step.File))
+		if err != nil {
+			return fmt.Errorf("failed to read migration %q: %w", step.File, err)
+		}
+
+		direction := "up"
+		if !step.Up {
+			direction = "down"
+		}
+
+		plan.Migrations = append(plan.Migrations, applyPlanMigration{
+			File:      step.File,
+			Version:   step.Version,
+			Direction: direction,
+			SQL:       string(content),
+		})
+
+		// Testdata is only inserted when the database is migrated from scratch
+		if insertTestData && plan.CreateDatabase {
+			var testdataFiles []string
+			testdataFiles, err = internal.FindTestdata(wd, step.Version)
+			if err != nil {
+				return fmt.Errorf("failed to find testdata: %w", err)
+			}
+			for _, p := range testdataFiles {
+				plan.Testdata = append(plan.Testdata, filepath.Base(p))
+			}
+		}
+	}
+
+	printApplyPlan(plan)
+
+	if output != "" {
+		var data []byte
+		data, err = json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode plan: %w", err)
+		}
+
+		err = os.WriteFile(output, data, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to write plan: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func printApplyPlan(plan *applyPlan) {
+	switch {
+	case plan.ResetDatabase:
+		fmt.Printf("Database %q would be dropped and recreated\n", plan.Database)
+	case plan.CreateDatabase:
+		fmt.Printf("Database %q would be created\n", plan.Database)
+	case plan.CurrentVersion == nil:
+		fmt.Printf("Database %q has no migrations applied\n", plan.Database)
+	default:
+		fmt.Printf("Database %q is at version %d\n", plan.Database, *plan.CurrentVersion)
+	}
+	fmt.Printf("Target version is %d\n", plan.TargetVersion)
+
+	for _, role := range plan.MissingRoles {
+		fmt.Printf("WARNING: role %q does not exist, apply would fail\n", role)
+	}
+
+	if len(plan.Migrations) == 0 {
+		fmt.Println("No migrations would be applied")
+	}
+
+	for _, migration := range plan.Migrations {
+		fmt.Println("")
+		fmt.Printf("-- %s (%s)\n", migration.File, migration.Direction)
+		fmt.Println(migration.SQL)
+	}
+
+	if len(plan.Testdata) > 0 {
+		fmt.Println("")
+		fmt.Println("Testdata that would be inserted:")
+		for _, testdata := range plan.Testdata {
+			fmt.Printf(" - %s\n", testdata)
+		}
+	}
+}
@@ -2,21 +2,18 @@ package cmd
 
 import (
 	"context"
-	"encoding/xml"
 	"errors"
 	"fmt"
-	"io/fs"
 	"log"
 	"os"
-	"path"
 	"path/filepath"
-	"strconv"
 	"strings"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/jackc/pgx/v5"
 	"github.com/printeers/trek/internal/configuration"
 	"github.com/printeers/trek/internal/postgres"
+	"github.com/printeers/trek/internal/psql"
 
 	// needed driver.
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
@@ -27,7 +24,14 @@ import (
 	"github.com/printeers/trek/internal/dbm"
 )
 
+var (
+	errModelOutOfSync        = errors.New("model is not in sync with the migrations, run trek generate")
+	errDownMigrationMismatch = errors.New("down migration doesn't restore the schema")
+)
+
 func NewCheckCommand() *cobra.Command {
+	var devPostgresFlags internal.DevPostgresFlags
+
 	checkCmd := &cobra.Command{
 		Use:   "check",
 		Short: "Validate all files",
@@ -52,62 +56,88 @@ func NewCheckCommand() *cobra.Command {
 				return fmt.Errorf("failed to get migrations directory: %w", err)
 			}
 
-			return checkAll(ctx, config, wd, migrationsDir)
+			postgresInstance, err := setupPostgresInstance(config, &devPostgresFlags)
+			if err != nil {
+				return fmt.Errorf("failed to setup tmp database: %w", err)
+			}
+			defer postgresInstance.Stop() //nolint:errcheck
+
+			return checkAll(ctx, config, wd, migrationsDir, postgresInstance, true)
 		},
 	}
 
+	devPostgresFlags.Register(checkCmd)
+
 	return checkCmd
 }
 
+// checkDatabase is the database in which check replays the migrations and testdata.
+const checkDatabase = "check"
+
+// checkAll runs all checks. If checkModel is set, it also checks that the
+// model has no changes that are missing in the migrations. Generate doesn't,
+// because those changes are what it generates.
+//
 //nolint:cyclop
 func checkAll(
 	ctx context.Context,
 	config *configuration.Config,
 	wd,
 	migrationsDir string,
+	postgresInstance postgres.Instance,
+	checkModel bool,
 ) error {
-	tmpPostgres, err := setupPostgresInstance(5434)
-	if err != nil {
-		return fmt.Errorf("failed to setup tmp database: %w", err)
-	}
-	defer tmpPostgres.Stop() //nolint:errcheck
-
-	tmpPostgresDSN := tmpPostgres.DSN("postgres")
-
-	conn, err := pgx.Connect(ctx, tmpPostgresDSN)
+	// The instance may be shared with generate, so remove everything from previous runs
+	err := postgresInstance.Reset(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to connect to tmp database: %w", err)
+		return fmt.Errorf("failed to reset tmp database: %w", err)
 	}
-	defer conn.Close(ctx)
 
 	for _, role := range config.Roles {
-		_, err = conn.Exec(ctx, fmt.Sprintf("CREATE ROLE %q WITH LOGIN PASSWORD 'postgres'", role.Name))
+		err = postgresInstance.CreateRole(ctx, role.Name)
 		if err != nil {
 			return fmt.Errorf("failed to create role %q: %w", role.Name, err)
 		}
 	}
 
+	err = postgresInstance.CreateDatabase(ctx, checkDatabase)
+	if err != nil {
+		return fmt.Errorf("failed to create %s database: %w", checkDatabase, err)
+	}
+
+	checkDSN := postgresInstance.DSN(checkDatabase)
+
 	migrationFiles, err := internal.FindMigrations(migrationsDir, true)
 	if err != nil {
 		return fmt.Errorf("failed to find migrations: %w", err)
 	}
 
-	hookOptions := &internal.HookOptions{
-		Env: map[string]string{
-			"TREK_POSTGRES_HOST":     "localhost",
-			"TREK_POSTGRES_PORT":     "5434",
-			"TREK_POSTGRES_USER":     "postgres",
-			"TREK_POSTGRES_PASSWORD": "postgres",
-			"TREK_POSTGRES_DATABASE": "postgres",
-			"TREK_POSTGRES_SSLMODE":  "disable",
-		},
+	// The check-pre hook runs against the empty database. A snapshot can only be restored into an empty database and
+	// would contain the changes of the hook, so snapshots are not used if the hook exists.
+	var restored uint
+	snapshots := newSnapshotCache(config, wd, migrationsDir, true)
+	if snapshots != nil && internal.HookExists(wd, "check-pre") {
+		log.Println("Not using snapshots, because the check-pre hook exists")
+		snapshots = nil
 	}
 
-	err = internal.RunHook(ctx, wd, "check-pre", hookOptions)
+	hookOptions := &internal.HookOptions{DSN: checkDSN}
+
+	err = internal.RunHook(ctx, config, wd, "check-pre", hookOptions)
 	if err != nil {
 		return fmt.Errorf("failed to run hook: %w", err)
 	}
 
+	if snapshots != nil {
+		restored, err = snapshots.Restore(ctx, checkDSN, migrationFiles)
+		if err != nil {
+			return fmt.Errorf("failed to restore snapshot: %w", err)
+		}
+		if restored > 0 {
+			log.Printf("Restored snapshot after migration %d of %d, skipping checks of those\n", restored, len(migrationFiles))
+		}
+	}
+
 	log.Println("Checking dbm file")
 
 	err = checkDBM(config, wd)
@@ -115,28 +145,77 @@ func checkAll(
 		return fmt.Errorf("failed to check dbm: %w", err)
 	}
 
+	log.Println("Linting model")
+
+	modelFindings, err := lintModel(config, wd)
+	if err != nil {
+		return fmt.Errorf("failed to lint model: %w", err)
+	}
+
+	err = reportFindings(modelFindings)
+	if err != nil {
+		return fmt.Errorf("failed to lint model: %w", err)
+	}
+
 	log.Println("Checking migration file names")
 
-	err = checkMigrationFileNames(migrationFiles)
+	err = internal.NewVersionScheme(config).ValidateMigrationFileNames(migrationFiles)
 	if err != nil {
 		return fmt.Errorf("failed to check migration file names: %w", err)
 	}
 
+	log.Printf("Checking %s\n", internal.SumFileName)
+
+	err = checkSum(wd, migrationsDir)
+	if err != nil {
+		return fmt.Errorf("failed to check %s: %w", internal.SumFileName, err)
+	}
+
+	log.Println("Linting migrations")
+
+	migrationFindings, err := lintMigrations(config, wd, migrationsDir, migrationFiles)
+	if err != nil {
+		return fmt.Errorf("failed to lint migrations: %w", err)
+	}
+
+	err = reportFindings(migrationFindings)
+	if err != nil {
+		return fmt.Errorf("failed to lint migrations: %w", err)
+	}
+
 	log.Println("Checking templates")
 
-	err = checkTemplates(config, uint(len(migrationFiles)))
+	latestVersion, err := internal.LatestMigrationVersion(migrationFiles)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	err = checkTemplates(config, latestVersion)
 	if err != nil {
 		return fmt.Errorf("failed to check templates: %w", err)
 	}
 
 	log.Println("Checking migrations and testdata")
 
-	err = checkMigrationsAndTestdata(ctx, wd, migrationsDir, tmpPostgresDSN, migrationFiles)
+	err = checkMigrationsAndTestdata(ctx, config, wd, migrationsDir, checkDSN, migrationFiles, restored)
 	if err != nil {
 		return fmt.Errorf("failed to check migrations and testdata: %w", err)
 	}
 
-	err = internal.RunHook(ctx, wd, "check-post", hookOptions)
+	if snapshots != nil && restored < uint(len(migrationFiles)) {
+		saveSnapshot(ctx, snapshots, checkDSN, migrationFiles)
+	}
+
+	if checkModel {
+		log.Println("Checking that the migrations match the model")
+
+		err = checkModelInSync(ctx, config, wd, migrationsDir, postgresInstance)
+		if err != nil {
+			return fmt.Errorf("failed to check model: %w", err)
+		}
+	}
+
+	err = internal.RunHook(ctx, config, wd, "check-post", hookOptions)
 	if err != nil {
 		return fmt.Errorf("failed to run hook: %w", err)
 	}
@@ -144,17 +223,82 @@ func checkAll(
 	return nil
 }
 
-func checkDBM(config *configuration.Config, wd string) error {
-	model := dbm.DBModel{}
+// checkModelInSync diffs the model against the migrations like generate does,
+// but without running the generate-pre hook. Any statement means the model has
+// been changed without generating a migration.
+func checkModelInSync(
+	ctx context.Context,
+	config *configuration.Config,
+	wd,
+	migrationsDir string,
+	postgresInstance postgres.Instance,
+) error {
+	empty, err := isModelEmpty(config, wd)
+	if err != nil {
+		return err
+	}
+	if empty {
+		return nil
+	}
+
+	tmpDir, err := os.MkdirTemp("", "trek-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir) //nolint:errcheck
+
+	migrationFiles, err := internal.FindMigrations(migrationsDir, true)
+	if err != nil {
+		return fmt.Errorf("failed to find migrations: %w", err)
+	}
 
-	m, err := os.ReadFile(filepath.Join(wd, fmt.Sprintf("%s.dbm", config.ModelName)))
+	postgresConn, err := pgx.Connect(ctx, postgresInstance.DSN("postgres"))
 	if err != nil {
-		return fmt.Errorf("failed to read model file: %w", err)
+		return fmt.Errorf("failed to connect to postgres database: %w", err)
 	}
+	defer postgresConn.Close(ctx)
 
-	err = xml.Unmarshal(m, &model)
+	targetConn, migrateConn, err := createGenerateDatabases(ctx, postgresInstance)
 	if err != nil {
-		return fmt.Errorf("failed to parse model: %w", err)
+		return err
+	}
+	defer targetConn.Close(ctx)
+	defer migrateConn.Close(ctx)
+
+	initial := len(migrationFiles) == 0
+
+	snapshots, err := prepareGenerateDatabases(
+		ctx, config, wd, tmpDir, migrationsDir, initial, postgresInstance, targetConn, migrateConn)
+	if err != nil {
+		return err
+	}
+
+	migration, err := diffMigrationStatements(
+		ctx, config, tmpDir, snapshots, migrationsDir, initial, postgresInstance, postgresConn, targetConn, migrateConn)
+	if err != nil {
+		return fmt.Errorf("failed to generate migration statements: %w", err)
+	}
+
+	statements := migration.allStatements()
+	if len(statements) == 0 && len(migration.permissionStatements) == 0 {
+		return nil
+	}
+
+	log.Println("The model has changes that are not in the migrations:")
+	for _, stmt := range statements {
+		fmt.Println(stmt.DDL + ";")
+	}
+	for _, stmt := range migration.permissionStatements {
+		fmt.Println(stmt)
+	}
+
+	return errModelOutOfSync
+}
+
+func checkDBM(config *configuration.Config, wd string) error {
+	model, err := dbm.ReadFile(filepath.Join(wd, fmt.Sprintf("%s.dbm", config.ModelName)))
+	if err != nil {
+		return err //nolint:wrapcheck
 	}
 
 	modelRoles := map[string]dbm.Role{}
@@ -208,46 +352,39 @@ func checkDBM(config *configuration.Config, wd string) error {
 	return nil
 }
 
-func checkMigrationFileNames(migrationFiles []string) error {
-	for _, migrationFile := range migrationFiles {
-		if !internal.RegexpMigrationFileName.MatchString(migrationFile) {
-			//nolint:err113
-			return fmt.Errorf("invalid migration file name %q", migrationFile)
-		}
+// checkSum makes sure that migrations and testdata haven't been changed after
+// they were hashed. Projects without sum file are skipped.
+func checkSum(wd, migrationsDir string) error {
+	problems, ok, err := internal.VerifySum(wd, migrationsDir)
+	if err != nil {
+		return err //nolint:wrapcheck
 	}
+	if !ok {
+		log.Printf("No %s found, run trek rehash to create it\n", internal.SumFileName)
 
-	existingMigrations := map[int]struct{}{}
-	for _, migrationFile := range migrationFiles {
-		index, err := strconv.Atoi(strings.Split(migrationFile, "_")[0])
-		if err != nil {
-			//nolint:err113
-			return fmt.Errorf("failed to parse migration index of file %q", migrationFile)
-		}
-
-		if _, ok := existingMigrations[index]; ok {
-			//nolint:err113
-			return fmt.Errorf("migration with index %d exists more than once", index)
-		}
+		return nil
+	}
 
-		if len(existingMigrations) != index-1 {
-			//nolint:err113
-			return fmt.Errorf("migration after index %d missing", len(existingMigrations))
+	if len(problems) > 0 {
+		for _, problem := range problems {
+			log.Println(problem)
 		}
+		log.Println("Released migrations must not be changed, run trek rehash if the change is intended")
 
-		existingMigrations[index] = struct{}{}
+		return internal.ErrSumMismatch
 	}
 
 	return nil
 }
 
-func checkTemplates(config *configuration.Config, migrationsCount uint) error {
+func checkTemplates(config *configuration.Config, latestVersion uint) error {
 	for _, ts := range config.Templates {
 		if _, err := os.Stat(ts.Path); errors.Is(err, os.ErrNotExist) {
 			//nolint:err113
 			return fmt.Errorf("templated file %q does not exist", ts.Path)
 		}
 
-		data, err := internal.ExecuteConfigTemplate(ts, migrationsCount)
+		data, err := internal.ExecuteConfigTemplate(ts, latestVersion+config.VersionOffset)
 		if err != nil {
 			return fmt.Errorf("failed to execute template: %w", err)
 		}
@@ -266,43 +403,172 @@ func checkTemplates(config *configuration.Config, migrationsCount uint) error {
 	return nil
 }
 
-func checkMigrationsAndTestdata(ctx context.Context, wd, migrationsDir, dsn string, migrationFiles []string) error {
-	m, err := migrate.New(fmt.Sprintf("file://%s", migrationsDir), dsn)
+// checkMigrationsAndTestdata applies the migrations and their testdata one by
+// one. The first skip migrations are expected to be restored from a snapshot.
+func checkMigrationsAndTestdata(
+	ctx context.Context,
+	config *configuration.Config,
+	wd,
+	migrationsDir,
+	dsn string,
+	migrationFiles []string,
+	skip uint,
+) error {
+	m, err := internal.NewMigrator(migrationsDir, dsn, config.VersionOffset)
 	if err != nil {
-		return fmt.Errorf("failed to initialize go-migrate: %w", err)
+		return fmt.Errorf("failed to initialize migrator: %w", err)
 	}
+	defer m.Close() //nolint:errcheck
+
+	for index := int(skip); index < len(migrationFiles); index++ { //nolint:gosec
+		file := migrationFiles[index]
+
+		var version uint
+		version, err = internal.GetMigrationVersion(file)
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		var hasDown bool
+		hasDown, err = internal.HasDownMigration(migrationsDir, file)
+		if err != nil {
+			return fmt.Errorf("failed to check down migration of %q: %w", file, err)
+		}
 
-	for index, file := range migrationFiles {
-		err = m.Steps(1)
+		// The down migration has to restore the schema from before the migration
+		var schemaBefore string
+		if hasDown {
+			schemaBefore, err = dumpSchema(ctx, dsn)
+			if err != nil {
+				return err
+			}
+		}
+
+		step := internal.MigrationStep{File: file, Version: version, Up: true}
+		err = m.Step(step)
 		if errors.Is(err, migrate.ErrNoChange) {
 			continue
 		} else if err != nil {
 			return fmt.Errorf("failed to apply migration %q: %w", file, err)
 		}
-		err = filepath.Walk(filepath.Join(wd, "testdata"), func(p string, _ fs.FileInfo, err error) error {
+
+		if hasDown {
+			err = checkMigrationRoundTrip(ctx, m, dsn, step, schemaBefore)
 			if err != nil {
 				return err
 			}
+		}
 
-			if strings.HasPrefix(path.Base(p), fmt.Sprintf("%03d", index+1)) {
-				// We have to use psql, because users might use commands like "\copy"
-				// which don't work by directly connecting to the database
-				err := postgres.PsqlFile(ctx, dsn, p)
-				if err != nil {
-					//nolint:err113
-					return fmt.Errorf("failed to apply testdata: %w", err)
-				}
+		var testdataFiles []string
+		testdataFiles, err = internal.FindTestdata(wd, version)
+		if err != nil {
+			return fmt.Errorf("failed to find testdata: %w", err)
+		}
 
-				return nil
+		for _, p := range testdataFiles {
+			// We have to use psql, because users might use commands like "\copy"
+			// which don't work by directly connecting to the database
+			err = psql.RunFile(ctx, dsn, p, nil)
+			if err != nil {
+				return fmt.Errorf("failed to apply testdata %q: %w", filepath.Base(p), err)
 			}
+		}
+	}
 
-			return nil
-		})
-		if err != nil {
-			//nolint:err113
-			return fmt.Errorf("failed to run testdata: %w", err)
+	return nil
+}
+
+// checkMigrationRoundTrip reverts the just applied migration with its down
+// migration, compares the schema with the one before the migration and
+// applies the migration again.
+func checkMigrationRoundTrip(
+	ctx context.Context,
+	m *internal.Migrator,
+	dsn string,
+	step internal.MigrationStep,
+	schemaBefore string,
+) error {
+	err := m.Step(internal.MigrationStep{
+		File:    internal.GetDownMigrationFileName(step.File),
+		Version: step.Version,
+		Up:      false,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to revert migration %q: %w", step.File, err)
+	}
+
+	schemaAfter, err := dumpSchema(ctx, dsn)
+	if err != nil {
+		return err
+	}
+
+	missing, leftOver := diffLines(schemaLines(schemaBefore), schemaLines(schemaAfter))
+	if len(missing) > 0 || len(leftOver) > 0 {
+		log.Printf("The down migration of %q doesn't restore the schema from before the migration\n", step.File)
+		for _, line := range missing {
+			log.Printf("Missing after the down migration: %s\n", line)
+		}
+		for _, line := range leftOver {
+			log.Printf("Left over after the down migration: %s\n", line)
 		}
+
+		return fmt.Errorf("%w: %q", errDownMigrationMismatch, step.File)
+	}
+
+	err = m.Step(step)
+	if err != nil {
+		return fmt.Errorf("failed to reapply migration %q after reverting it: %w", step.File, err)
 	}
 
 	return nil
 }
+
+// dumpSchema returns the schema of the database, without the migrations table of golang-migrate.
+func dumpSchema(ctx context.Context, dsn string) (string, error) {
+	//nolint:wrapcheck
+	return postgres.PgDump(ctx, dsn, []string{
+		"--schema-only",
+		"--exclude-table=public.schema_migrations",
+	})
+}
+
+// schemaLines returns the lines of a dump that describe the schema. pg_dump
+// adds comments with its version and meta commands with a random key. A down
+// migration adds dropped columns back at the end of the table, so the commas
+// that separate the columns are removed and diffLines ignores the order.
+func schemaLines(dump string) []string {
+	var lines []string
+	for line := range strings.SplitSeq(dump, "\n") {
+		if strings.TrimSpace(line) != "" && !strings.HasPrefix(line, "--") && !strings.HasPrefix(line, `\`) {
+			lines = append(lines, strings.TrimSuffix(line, ","))
+		}
+	}
+
+	return lines
+}
+
+// diffLines returns the old lines that the new lines don't have and the new
+// lines that the old lines don't have, regardless of their order.
+func diffLines(oldLines, newLines []string) (missing, added []string) {
+	counts := map[string]int{}
+	for _, line := range oldLines {
+		counts[line]++
+	}
+
+	for _, line := range newLines {
+		if counts[line] > 0 {
+			counts[line]--
+		} else {
+			added = append(added, line)
+		}
+	}
+
+	for _, line := range oldLines {
+		if counts[line] > 0 {
+			counts[line]--
+			missing = append(missing, line)
+		}
+	}
+
+	return missing, added
+}
@@ -0,0 +1,391 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/cobra"
+
+	"github.com/printeers/trek/internal"
+	"github.com/printeers/trek/internal/configuration"
+	internalpostgres "github.com/printeers/trek/internal/postgres"
+	"github.com/printeers/trek/internal/psql"
+)
+
+// ErrDriftDetected is returned by drift when the live database differs from the migrations.
+var ErrDriftDetected = &ExitError{Code: 2, Message: "schema drift detected"}
+
+var (
+	regexpDumpPrivilegeLine = regexp.MustCompile(`(?m)^(?:ALTER .* OWNER TO|GRANT|REVOKE|ALTER DEFAULT PRIVILEGES) .*;\n`)
+	regexpDumpRole          = regexp.MustCompile(
+		`(?:OWNER TO|FOR ROLE|FOR USER| TO| FROM|GRANTED BY) ("(?:[^"]|"")+"|[\w$]+)`,
+	)
+)
+
+//nolint:gocognit,cyclop
+func NewDriftCommand() *cobra.Command {
+	var (
+		postgresFlags  internal.PostgresFlags
+		writeMigration string
+		markApplied    bool
+
+		devPostgresFlags internal.DevPostgresFlags
+	)
+
+	driftCmd := &cobra.Command{
+		Use:   "drift",
+		Short: "Compare the schema of a running database with the migrations",
+		PersistentPreRun: func(cmd *cobra.Command, _ []string) {
+			internal.InitializeFlags(cmd)
+		},
+		Args: func(_ *cobra.Command, _ []string) error {
+			if writeMigration != "" && !internal.RegexpMigrationName.MatchString(writeMigration) {
+				//nolint:err113
+				return errors.New("migration name must be lower-kebab-case and must not start or end with a number or dash")
+			}
+			if markApplied && writeMigration == "" {
+				//nolint:err113
+				return errors.New("--mark-applied only works with --write-migration")
+			}
+
+			return nil
+		},
+		RunE: func(_ *cobra.Command, _ []string) error {
+			ctx := context.Background()
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("failed to get working directory: %w", err)
+			}
+
+			config, err := configuration.ReadConfig(wd)
+			if err != nil {
+				return fmt.Errorf("failed to read config: %w", err)
+			}
+
+			migrationsDir, err := internal.GetMigrationsDir(wd)
+			if err != nil {
+				return fmt.Errorf("failed to get migrations directory: %w", err)
+			}
+
+			migrationFiles, err := internal.FindMigrations(migrationsDir, true)
+			if err != nil {
+				return fmt.Errorf("failed to find migrations: %w", err)
+			}
+
+			latestVersion, err := internal.LatestMigrationVersion(migrationFiles)
+			if err != nil {
+				return err //nolint:wrapcheck
+			}
+
+			tmpDir, err := os.MkdirTemp("", "trek-")
+			if err != nil {
+				return fmt.Errorf("failed to create temporary directory: %w", err)
+			}
+			defer os.RemoveAll(tmpDir) //nolint:errcheck
+
+			liveVersion, err := getLiveVersion(ctx, config, postgresFlags.DSN(config.DatabaseName), migrationFiles)
+			if err != nil {
+				return err
+			}
+			if liveVersion > 0 {
+				migrationFiles, err = internal.MigrationsUpTo(migrationFiles, liveVersion)
+				if err != nil {
+					return err //nolint:wrapcheck
+				}
+			}
+			if writeMigration != "" && liveVersion > 0 && liveVersion != latestVersion {
+				//nolint:err113
+				return fmt.Errorf(
+					"database is at version %d, but the latest migration is version %d, apply the pending migrations before writing a migration", //nolint:lll
+					liveVersion,
+					latestVersion,
+				)
+			}
+
+			postgresInstance, err := setupPostgresInstance(config, &devPostgresFlags)
+			if err != nil {
+				return fmt.Errorf("failed to setup instance: %w", err)
+			}
+			defer postgresInstance.Stop() //nolint:errcheck
+
+			migration, err := detectDrift(
+				ctx,
+				config,
+				tmpDir,
+				migrationsDir,
+				len(migrationFiles) == 0,
+				liveVersion,
+				postgresFlags.DSN(config.DatabaseName),
+				postgresInstance,
+				newSnapshotCache(config, wd, migrationsDir, false),
+			)
+			if err != nil {
+				return err
+			}
+
+			statements := migration.up()
+			if statements == "" {
+				log.Println("No drift detected")
+
+				return nil
+			}
+
+			fmt.Println("")
+			fmt.Println("--")
+			fmt.Println(statements)
+			fmt.Println("--")
+
+			if writeMigration != "" {
+				scheme := internal.NewVersionScheme(config)
+				migrationNumber := scheme.NewVersion(latestVersion)
+				newMigrationFilePath := filepath.Join(
+					migrationsDir,
+					scheme.MigrationFileName(migrationNumber, writeMigration),
+				)
+
+				err = writeMigrationFiles(ctx, config, wd, newMigrationFilePath, migration)
+				if err != nil {
+					return err
+				}
+
+				err = writeTemplateFiles(config, migrationNumber)
+				if err != nil {
+					return fmt.Errorf("failed to write template files: %w", err)
+				}
+
+				// The live database already has the changes of the migration, so apply would fail to run it
+				if markApplied {
+					err = markMigrationApplied(config, migrationsDir, postgresFlags.DSN(config.DatabaseName), migrationNumber)
+					if err != nil {
+						return err
+					}
+				} else {
+					log.Printf(
+						"WARNING: the live database already has the changes of %q, but is not at its version. "+
+							"Use --mark-applied or set the version yourself, otherwise apply fails to run it\n",
+						filepath.Base(newMigrationFilePath),
+					)
+				}
+			}
+
+			return ErrDriftDetected
+		},
+	}
+
+	postgresFlags.Register(driftCmd)
+	devPostgresFlags.Register(driftCmd)
+	driftCmd.Flags().StringVar(&writeMigration, "write-migration", "", "Write the statements that record the drift as a new migration with the given name") //nolint:lll
+	driftCmd.Flags().BoolVar(
+		&markApplied,
+		"mark-applied",
+		false,
+		"Set the version of the live database to the written migration, because it already has its changes. Only works with --write-migration", //nolint:lll
+	)
+
+	return driftCmd
+}
+
+// markMigrationApplied sets the version of the live database to the given
+// version without running the migration.
+func markMigrationApplied(config *configuration.Config, migrationsDir, dsn string, version uint) error {
+	m, err := internal.NewMigrator(migrationsDir, dsn, config.VersionOffset)
+	if err != nil {
+		return fmt.Errorf("failed to initialize migrator: %w", err)
+	}
+	defer m.Close() //nolint:errcheck
+
+	err = m.Force(version)
+	if err != nil {
+		return fmt.Errorf("failed to set the version of the live database to %d: %w", version, err)
+	}
+	log.Printf("Set the version of the live database to %d\n", version)
+
+	return nil
+}
+
+// detectDrift replays the migrations up to the version of the live database,
+// all if it is 0, and loads a schema-only dump of the live database into an
+// embedded instance. The returned migration brings the migrations in line with
+// the live database.
+//
+//nolint:cyclop
+func detectDrift(
+	ctx context.Context,
+	config *configuration.Config,
+	tmpDir,
+	migrationsDir string,
+	initial bool,
+	liveVersion uint,
+	liveDSN string,
+	postgresInstance internalpostgres.Instance,
+	snapshots *internalpostgres.SnapshotCache,
+) (*generatedMigration, error) {
+	log.Println("Dumping live database schema")
+
+	liveDump, err := internalpostgres.PgDump(ctx, liveDSN, []string{
+		"--schema-only",
+		"--exclude-table=public.schema_migrations",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to dump live database: %w", err)
+	}
+
+	liveDumpFile := filepath.Join(tmpDir, "live.sql")
+	err = os.WriteFile(liveDumpFile, []byte(removeUnknownRoles(liveDump, config.RoleNames())), 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write live.sql file: %w", err)
+	}
+
+	postgresConn, err := pgx.Connect(ctx, postgresInstance.DSN("postgres"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to postgres database: %w", err)
+	}
+	defer postgresConn.Close(ctx)
+
+	for _, role := range config.Roles {
+		err = postgresInstance.CreateRole(ctx, role.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create role %q: %w", role.Name, err)
+		}
+	}
+
+	for _, database := range []string{"live", "migrate"} {
+		err = postgresInstance.CreateDatabase(ctx, database)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s database: %w", database, err)
+		}
+	}
+
+	log.Println("Loading live database schema")
+
+	// We have to use psql, because pg_dump output contains psql meta-commands
+	err = psql.RunFile(ctx, postgresInstance.DSN("live"), liveDumpFile, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load live database schema: %w", err)
+	}
+
+	liveConn, err := pgx.Connect(ctx, postgresInstance.DSN("live"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to live database: %w", err)
+	}
+	defer liveConn.Close(ctx)
+
+	migrateConn, err := pgx.Connect(ctx, postgresInstance.DSN("migrate"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to migrate database: %w", err)
+	}
+	defer migrateConn.Close(ctx)
+
+	log.Println("Replaying migrations")
+
+	if !initial {
+		err = executeMigrateSQLUpTo(ctx, config, snapshots, migrationsDir, liveVersion, migrateConn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute migrate sql: %w", err)
+		}
+	}
+
+	log.Println("Comparing schemas")
+
+	statements, err := internal.DiffStatements(
+		ctx, postgresConn, migrateConn, liveConn, internal.WithTimeouts(config.Timeouts))
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff: %w", err)
+	}
+
+	downStatements, err := internal.DiffStatements(
+		ctx, postgresConn, liveConn, migrateConn, internal.WithTimeouts(config.Timeouts))
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff down: %w", err)
+	}
+
+	permissionStatements, permissionDownStatements, err := generateMissingPermissionStatements(
+		ctx,
+		tmpDir,
+		statements,
+		liveConn,
+		migrateConn,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate missing permission statements: %w", err)
+	}
+
+	return &generatedMigration{
+		statements:               statements,
+		permissionStatements:     permissionStatements,
+		permissionDownStatements: permissionDownStatements,
+		downStatements:           downStatements,
+	}, nil
+}
+
+// getLiveVersion returns the version of the live database. It returns 0 if the
+// database has no version, then it is compared with all migrations.
+func getLiveVersion(
+	ctx context.Context,
+	config *configuration.Config,
+	dsn string,
+	migrationFiles []string,
+) (uint, error) {
+	conn, err := pgx.Connect(ctx, dsn+"&default_transaction_read_only=on")
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to live database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	version, dirty, found, err := internalpostgres.GetSchemaMigrationsVersion(ctx, conn)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get database version: %w", err)
+	}
+	if !found {
+		log.Println("The live database has no version, comparing it with all migrations")
+
+		return 0, nil
+	}
+	if dirty {
+		//nolint:err113
+		return 0, fmt.Errorf("database version %d is dirty, fix it manually before checking for drift", version)
+	}
+
+	version, err = internal.FileVersion(version, config.VersionOffset)
+	if err != nil {
+		return 0, err //nolint:wrapcheck
+	}
+
+	latestVersion, err := internal.LatestMigrationVersion(migrationFiles)
+	if err != nil {
+		return 0, err //nolint:wrapcheck
+	}
+	if version < latestVersion {
+		log.Printf("The live database is at version %d, comparing it with the migrations up to that version\n", version)
+	}
+
+	return version, nil
+}
+
+// removeUnknownRoles removes the owners and privileges of roles that are not
+// in the config from the dump. The roles don't exist in the instance the dump
+// is loaded into, and migrations must not refer to them either.
+func removeUnknownRoles(dump string, roles []string) string {
+	return regexpDumpPrivilegeLine.ReplaceAllStringFunc(dump, func(line string) string {
+		for _, match := range regexpDumpRole.FindAllStringSubmatch(line, -1) {
+			role := match[1]
+			if strings.HasPrefix(role, `"`) {
+				role = strings.ReplaceAll(strings.Trim(role, `"`), `""`, `"`)
+			}
+			if role != "PUBLIC" && !slices.Contains(roles, role) {
+				return ""
+			}
+		}
+
+		return line
+	})
+}
@@ -2,18 +2,24 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/jackc/pgx/v5"
 	"github.com/spf13/cobra"
+	"github.com/stripe/pg-schema-diff/pkg/diff"
 
 	"github.com/printeers/trek/internal"
 	"github.com/printeers/trek/internal/configuration"
@@ -33,6 +39,26 @@ func (e *ExitError) Error() string {
 // ErrDiffDetected is returned by generate when diff statements are generated.
 var ErrDiffDetected = &ExitError{Code: 2, Message: "diff statements detected"}
 
+var (
+	regexpCreateIndexConcurrently = regexp.MustCompile(
+		`^CREATE (?:UNIQUE )?INDEX CONCURRENTLY (?:IF NOT EXISTS )?(\S+) ON (?:ONLY )?(\S+)`,
+	)
+	regexpDropIndexConcurrently = regexp.MustCompile(`^DROP INDEX CONCURRENTLY (?:IF EXISTS )?(\S+)`)
+	regexpIdentifier            = regexp.MustCompile(`"((?:[^"]|"")*)"|([^."]+)`)
+	regexpDollarQuote           = regexp.MustCompile(`\$\w*\$`)
+	regexpDefaultPrivilegeGrant = regexp.MustCompile(
+		`^(ALTER DEFAULT PRIVILEGES .*?) GRANT (.*) TO (\S+?)(?: WITH GRANT OPTION)?;$`,
+	)
+)
+
+var errSemicolonInConcurrentStatement = errors.New(
+	"statement of the non-transactional migration contains a semicolon, which golang-migrate would split it at, " +
+		"change the model or set concurrent_index_ops to false",
+)
+
+// ErrDeniedHazards is returned by generate when a statement has a hazard that is denied in the config.
+var ErrDeniedHazards = &ExitError{Code: 3, Message: "denied hazards detected"}
+
 //nolint:gocognit,cyclop
 func NewGenerateCommand() *cobra.Command {
 	var (
@@ -42,6 +68,10 @@ func NewGenerateCommand() *cobra.Command {
 		stdout      bool
 		check       bool
 		errorOnDiff bool
+		hazards     []string // acknowledged hazard types
+		format      string
+
+		devPostgresFlags internal.DevPostgresFlags
 	)
 
 	generateCmd := &cobra.Command{
@@ -51,6 +81,14 @@ func NewGenerateCommand() *cobra.Command {
 			internal.InitializeFlags(cmd)
 		},
 		Args: func(_ *cobra.Command, args []string) error {
+			if format != formatSQL && format != formatJSON {
+				return fmt.Errorf("%w %q, use %q or %q", errInvalidFormat, format, formatSQL, formatJSON)
+			}
+			if format == formatJSON && !stdout {
+				//nolint:err113
+				return errors.New("the json format only works with --stdout")
+			}
+
 			if stdout {
 				if len(args) != 0 {
 					//nolint:err113
@@ -96,7 +134,16 @@ func NewGenerateCommand() *cobra.Command {
 				return fmt.Errorf("failed to find migrations: %w", err)
 			}
 
+			// The instance is kept running across regenerations and checks, only the databases are recreated
+			postgresInstance, err := setupPostgresInstance(config, &devPostgresFlags)
+			if err != nil {
+				return fmt.Errorf("failed to setup instance: %w", err)
+			}
+			defer postgresInstance.Stop() //nolint:errcheck
+
 			var initialFunc, continuousFunc func() error
+			// Files written by generate itself must not trigger a regeneration
+			var ignoredFilePaths []string
 
 			if stdout {
 				initialFunc = func() error {
@@ -107,13 +154,24 @@ func NewGenerateCommand() *cobra.Command {
 					}
 
 					if check {
-						err = checkAll(ctx, config, wd, migrationsDir)
+						err = checkAll(ctx, config, wd, migrationsDir, postgresInstance, false)
 						if err != nil {
 							return err
 						}
 					}
 
-					err = runWithStdout(ctx, config, wd, tmpDir, migrationsDir, len(migrationFiles) == 0, errorOnDiff)
+					err = runWithStdout(
+						ctx,
+						config,
+						wd,
+						tmpDir,
+						migrationsDir,
+						len(migrationFiles) == 0,
+						errorOnDiff,
+						hazards,
+						format,
+						postgresInstance,
+					)
 					if err != nil {
 						return err
 					}
@@ -123,13 +181,30 @@ func NewGenerateCommand() *cobra.Command {
 				}
 
 				continuousFunc = func() error {
+					// Migrations may have been added or removed since the last run
+					migrationFiles, err = internal.FindMigrations(migrationsDir, true)
+					if err != nil {
+						return fmt.Errorf("failed to find migrations: %w", err)
+					}
+
 					var tmpDir string
 					tmpDir, err = os.MkdirTemp("", "trek-")
 					if err != nil {
 						return fmt.Errorf("failed to create temporary directory: %w", err)
 					}
 
-					err = runWithStdout(ctx, config, wd, tmpDir, migrationsDir, len(migrationFiles) == 0, errorOnDiff)
+					err = runWithStdout(
+						ctx,
+						config,
+						wd,
+						tmpDir,
+						migrationsDir,
+						len(migrationFiles) == 0,
+						errorOnDiff,
+						hazards,
+						format,
+						postgresInstance,
+					)
 					if err != nil {
 						return err
 					}
@@ -143,7 +218,8 @@ func NewGenerateCommand() *cobra.Command {
 				var migrationNumber uint
 				newMigrationFilePath, migrationNumber, err = internal.GetNewMigrationFilePath(
 					migrationsDir,
-					uint(len(migrationFiles)),
+					internal.NewVersionScheme(config),
+					migrationFiles,
 					migrationName,
 					overwrite,
 				)
@@ -151,14 +227,26 @@ func NewGenerateCommand() *cobra.Command {
 					return fmt.Errorf("failed to get new migration file path: %w", err)
 				}
 
+				ignoredFilePaths, err = generatedMigrationFilePaths(internal.NewVersionScheme(config), newMigrationFilePath)
+				if err != nil {
+					return err
+				}
+
 				defer func() {
 					if dev && cleanup {
-						if _, err = os.Stat(newMigrationFilePath); err == nil {
-							err = os.Remove(newMigrationFilePath)
-							if err != nil {
-								log.Printf("Failed to delete new migration file: %v\n", err)
+						filePaths, _ := generatedMigrationFilePaths(internal.NewVersionScheme(config), newMigrationFilePath)
+						for _, p := range filePaths {
+							if _, err = os.Stat(p); err == nil {
+								err = os.Remove(p)
+								if err != nil {
+									log.Printf("Failed to delete new migration file: %v\n", err)
+								}
 							}
 						}
+						err = internal.UpdateSum(wd, migrationsDir, migrationNumber)
+						if err != nil {
+							log.Printf("Failed to update %s: %v\n", internal.SumFileName, err)
+						}
 					}
 				}()
 
@@ -171,13 +259,23 @@ func NewGenerateCommand() *cobra.Command {
 
 					var updated bool
 					updated, err = runWithFile(
-						ctx, config, wd, tmpDir, migrationsDir, newMigrationFilePath, migrationNumber, errorOnDiff)
+						ctx,
+						config,
+						wd,
+						tmpDir,
+						migrationsDir,
+						newMigrationFilePath,
+						migrationNumber,
+						errorOnDiff,
+						hazards,
+						postgresInstance,
+					)
 					if err != nil {
 						return err
 					}
 
 					if updated && check {
-						err = checkAll(ctx, config, wd, migrationsDir)
+						err = checkAll(ctx, config, wd, migrationsDir, postgresInstance, false)
 						if err != nil {
 							return err
 						}
@@ -196,17 +294,62 @@ func NewGenerateCommand() *cobra.Command {
 				return err
 			}
 
-			if dev {
-				for {
-					time.Sleep(time.Millisecond * 100)
-					err = continuousFunc()
+			if !dev {
+				return nil
+			}
+
+			// Stop watching on interrupt, so that the deferred cleanup runs
+			var stop context.CancelFunc
+			ctx, stop = signal.NotifyContext(ctx, os.Interrupt)
+			defer stop()
+
+			watcher, err := newGenerateWatcher(config, wd, migrationsDir, ignoredFilePaths)
+			if err != nil {
+				return err
+			}
+			// The watcher is replaced when the config is reloaded
+			defer func() { _ = watcher.Close() }()
+
+			log.Println("Watching for changes")
+
+			for {
+				var changed []string
+				changed, err = watcher.Wait(ctx)
+				if errors.Is(err, context.Canceled) {
+					return nil
+				}
+				if err != nil {
+					return fmt.Errorf("failed to watch for changes: %w", err)
+				}
+
+				log.Printf("Changes detected in %s\n", strings.Join(relativePaths(wd, changed), ", "))
+
+				if slices.Contains(changed, filepath.Join(wd, configuration.FileName)) {
+					var newConfig *configuration.Config
+					newConfig, err = configuration.ReadConfig(wd)
 					if err != nil {
-						log.Printf("Failed to run: %v\n", err)
+						log.Printf("Failed to reload config, keeping the previous one: %v\n", err)
+					} else {
+						config = newConfig
+						log.Println("Reloaded config")
+
+						// The model and the templates are named in the config
+						var newWatcher *internal.Watcher
+						newWatcher, err = newGenerateWatcher(config, wd, migrationsDir, ignoredFilePaths)
+						if err != nil {
+							log.Printf("Failed to watch the files of the reloaded config, keeping the previous ones: %v\n", err)
+						} else {
+							_ = watcher.Close()
+							watcher = newWatcher
+						}
 					}
 				}
-			}
 
-			return nil
+				err = continuousFunc()
+				if err != nil {
+					log.Printf("Failed to run: %v\n", err)
+				}
+			}
 		},
 	}
 
@@ -216,12 +359,92 @@ func NewGenerateCommand() *cobra.Command {
 	generateCmd.Flags().BoolVar(&stdout, "stdout", false, "Output migration statements to stdout")
 	generateCmd.Flags().BoolVar(&check, "check", true, "Run checks after generating the migration")
 	generateCmd.Flags().BoolVar(&errorOnDiff, "error-on-diff", false, "Exit with code 2 if diff statements are generated")
+	generateCmd.Flags().StringVar(&format, "format", formatSQL, "Output format of --stdout, either sql or json")
+	devPostgresFlags.Register(generateCmd)
+	generateCmd.Flags().StringSliceVar(&hazards, "acknowledge-hazards", nil, "Hazard types to accept even if they are denied in the config") //nolint:lll
 
 	return generateCmd
 }
 
-func setupPostgresInstance(port uint32) (postgres.Instance, error) {
+// watchDebounce is the time to wait after the last change before regenerating.
+// Saving a model or checking out a branch touches many files at once.
+const watchDebounce = 200 * time.Millisecond
+
+// newGenerateWatcher watches all inputs of generate: the model, the config,
+// the migrations and the testdata.
+func newGenerateWatcher(
+	config *configuration.Config,
+	wd,
+	migrationsDir string,
+	ignoredFilePaths []string,
+) (*internal.Watcher, error) {
+	ignoredFilePaths = slices.Clone(ignoredFilePaths)
+	for _, ts := range config.Templates {
+		templatePath, err := filepath.Abs(ts.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute template path: %w", err)
+		}
+		ignoredFilePaths = append(ignoredFilePaths, templatePath)
+	}
+
+	watcher, err := internal.NewWatcher(
+		[]string{
+			filepath.Join(wd, fmt.Sprintf("%s.dbm", config.ModelName)),
+			filepath.Join(wd, configuration.FileName),
+		},
+		[]string{migrationsDir},
+		// Testdata may be organized in subdirectories
+		[]string{filepath.Join(wd, "testdata")},
+		ignoredFilePaths,
+		watchDebounce,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create watcher: %w", err)
+	}
+
+	return watcher, nil
+}
+
+func relativePaths(wd string, paths []string) []string {
+	relative := make([]string, 0, len(paths))
+	for _, p := range paths {
+		rel, err := filepath.Rel(wd, p)
+		if err != nil {
+			rel = p
+		}
+		relative = append(relative, rel)
+	}
+
+	return relative
+}
+
+// setupPostgresInstance starts the instance used to generate and check
+// migrations. If a DSN is set in the flag or the config, that server is used.
+// Otherwise an embedded instance is started on the port of the flag or the
+// config. If neither sets a port, a free one is picked.
+func setupPostgresInstance(
+	config *configuration.Config,
+	devPostgresFlags *internal.DevPostgresFlags,
+) (postgres.Instance, error) {
+	dsn := devPostgresFlags.DSN
+	if dsn == "" {
+		dsn = config.GetDevPostgresDSN()
+	}
+
+	port := devPostgresFlags.Port
+	if port == 0 {
+		port = config.GetDevPostgresPort()
+	}
+
 	pgInstance := postgres.NewPostgresInstance()
+	if dsn != "" {
+		var err error
+		pgInstance, err = postgres.NewExternalPostgresInstance(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to setup external instance: %w", err)
+		}
+	}
+
 	err := pgInstance.Start(port)
 	if err != nil {
 		return nil, fmt.Errorf("failed to start database: %w", err)
@@ -230,6 +453,55 @@ func setupPostgresInstance(port uint32) (postgres.Instance, error) {
 	return pgInstance, nil
 }
 
+// connectAndResetPostgresInstance removes the databases and roles of previous
+// runs from the instance and connects to its postgres database.
+func connectAndResetPostgresInstance(ctx context.Context, postgresInstance postgres.Instance) (*pgx.Conn, error) {
+	err := postgresInstance.Reset(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reset instance: %w", err)
+	}
+
+	postgresConn, err := pgx.Connect(ctx, postgresInstance.DSN("postgres"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to postgres database: %w", err)
+	}
+
+	return postgresConn, nil
+}
+
+// createGenerateDatabases creates the target database, which gets the schema
+// of the model, and the migrate database, which gets the migrations.
+func createGenerateDatabases(
+	ctx context.Context,
+	postgresInstance postgres.Instance,
+) (targetConn, migrateConn *pgx.Conn, err error) {
+	err = postgresInstance.CreateDatabase(ctx, "target")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create target database: %w", err)
+	}
+
+	targetConn, err = pgx.Connect(ctx, postgresInstance.DSN("target"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to connect to target database: %w", err)
+	}
+
+	err = postgresInstance.CreateDatabase(ctx, "migrate")
+	if err != nil {
+		_ = targetConn.Close(ctx)
+
+		return nil, nil, fmt.Errorf("failed to create migrate database: %w", err)
+	}
+
+	migrateConn, err = pgx.Connect(ctx, postgresInstance.DSN("migrate"))
+	if err != nil {
+		_ = targetConn.Close(ctx)
+
+		return nil, nil, fmt.Errorf("failed to connect to migrate database: %w", err)
+	}
+
+	return targetConn, migrateConn, nil
+}
+
 //nolint:gocognit,cyclop
 func runWithStdout(
 	ctx context.Context,
@@ -239,53 +511,36 @@ func runWithStdout(
 	migrationsDir string,
 	initial bool,
 	errorOnDiff bool,
+	acknowledgedHazards []string,
+	format string,
+	postgresInstance postgres.Instance,
 ) error {
-	updated, err := checkIfUpdated(config, wd)
+	empty, err := isModelEmpty(config, wd)
 	if err != nil {
-		return fmt.Errorf("failed to check if model has been updated: %w", err)
+		return err
 	}
-	if updated {
-		targetInstance, err := setupPostgresInstance(5432)
+	if !empty {
+		postgresConn, err := connectAndResetPostgresInstance(ctx, postgresInstance)
 		if err != nil {
-			return fmt.Errorf("failed to setup instance: %w", err)
-		}
-		defer targetInstance.Stop() //nolint:errcheck
-
-		postgresConn, err := pgx.Connect(ctx, targetInstance.DSN("postgres"))
-		if err != nil {
-			return fmt.Errorf("failed to connect to postgres database: %w", err)
+			return err
 		}
 		defer postgresConn.Close(ctx)
 
-		_, err = postgresConn.Exec(ctx, "CREATE DATABASE target;")
+		targetConn, migrateConn, err := createGenerateDatabases(ctx, postgresInstance)
 		if err != nil {
-			return fmt.Errorf("failed to create target database: %w", err)
-		}
-
-		targetConn, err := pgx.Connect(ctx, targetInstance.DSN("target"))
-		if err != nil {
-			return fmt.Errorf("failed to connect to target database: %w", err)
+			return err
 		}
 		defer targetConn.Close(ctx)
-
-		_, err = postgresConn.Exec(ctx, "CREATE DATABASE migrate;")
-		if err != nil {
-			return fmt.Errorf("failed to create migrate database: %w", err)
-		}
-
-		migrateConn, err := pgx.Connect(ctx, targetInstance.DSN("migrate"))
-		if err != nil {
-			return fmt.Errorf("failed to connect to migrate database: %w", err)
-		}
 		defer migrateConn.Close(ctx)
 
-		statements, err := generateMigrationStatements(
+		migration, err := generateMigrationStatements(
 			ctx,
 			config,
 			wd,
 			tmpDir,
 			migrationsDir,
 			initial,
+			postgresInstance,
 			postgresConn,
 			targetConn,
 			migrateConn,
@@ -294,6 +549,40 @@ func runWithStdout(
 			return fmt.Errorf("failed to generate migration statements: %w", err)
 		}
 
+		err = writeModelOutputs(ctx, config, wd, tmpDir)
+		if err != nil {
+			return err
+		}
+
+		if format == formatJSON {
+			err = checkHazards(config, migration.allStatements(), acknowledgedHazards)
+			if err != nil {
+				return err
+			}
+
+			// The generate-migration-post hook is skipped, because it operates on SQL files
+			err = printMigrationJSON(migration)
+			if err != nil {
+				return err
+			}
+
+			if errorOnDiff && (migration.up() != "" || migration.hasConcurrentMigration()) {
+				return ErrDiffDetected
+			}
+
+			return nil
+		}
+
+		err = checkHazards(config, migration.allStatements(), acknowledgedHazards)
+		if err != nil {
+			return err
+		}
+
+		statements := migration.up()
+		if migration.hasConcurrentMigration() {
+			statements += "\n" + migration.concurrentUp()
+		}
+
 		file, err := os.CreateTemp("", "migration")
 		if err != nil {
 			return fmt.Errorf("failed get temporary migration file: %w", err)
@@ -308,7 +597,7 @@ func runWithStdout(
 			return fmt.Errorf("failed to write temporary migration file: %w", err)
 		}
 
-		err = internal.RunHook(ctx, wd, "generate-migration-post", &internal.HookOptions{
+		err = internal.RunHook(ctx, config, wd, "generate-migration-post", &internal.HookOptions{
 			Args: []string{file.Name()},
 		})
 		if err != nil {
@@ -349,60 +638,56 @@ func runWithFile(
 	newMigrationFilePath string,
 	migrationNumber uint,
 	errorOnDiff bool,
+	acknowledgedHazards []string,
+	postgresInstance postgres.Instance,
 ) (bool, error) {
-	updated, err := checkIfUpdated(config, wd)
+	empty, err := isModelEmpty(config, wd)
 	if err != nil {
-		return false, fmt.Errorf("failed to check if model has been updated: %w", err)
+		return false, err
 	}
-	if updated {
-		if _, err = os.Stat(newMigrationFilePath); err == nil {
-			err = os.Remove(newMigrationFilePath)
-			if err != nil {
-				return false, fmt.Errorf("failed to delete generated migration file: %w", err)
-			}
-		}
-
-		postgresInstance, err := setupPostgresInstance(5432)
-		if err != nil {
-			return false, fmt.Errorf("failed to setup instance: %w", err)
-		}
-		defer postgresInstance.Stop() //nolint:errcheck
+	if !empty {
+		scheme := internal.NewVersionScheme(config)
 
-		postgresConn, err := pgx.Connect(ctx, postgresInstance.DSN("postgres"))
+		filePaths, err := generatedMigrationFilePaths(scheme, newMigrationFilePath)
 		if err != nil {
-			return false, fmt.Errorf("failed to connect to postgres database: %w", err)
+			return false, err
 		}
-		defer postgresConn.Close(ctx)
-
-		_, err = postgresConn.Exec(ctx, "CREATE DATABASE target;")
-		if err != nil {
-			return false, fmt.Errorf("failed to create target database: %w", err)
+		for _, p := range filePaths {
+			if _, err = os.Stat(p); err == nil {
+				err = os.Remove(p)
+				if err != nil {
+					return false, fmt.Errorf("failed to delete generated migration file: %w", err)
+				}
+			}
 		}
 
-		targetConn, err := pgx.Connect(ctx, postgresInstance.DSN("target"))
+		// The migration is the first one if no other migration is left
+		previousMigrationFiles, err := internal.FindMigrations(migrationsDir, true)
 		if err != nil {
-			return false, fmt.Errorf("failed to connect to target database: %w", err)
+			return false, fmt.Errorf("failed to find migrations: %w", err)
 		}
-		defer targetConn.Close(ctx)
 
-		_, err = postgresConn.Exec(ctx, "CREATE DATABASE migrate;")
+		postgresConn, err := connectAndResetPostgresInstance(ctx, postgresInstance)
 		if err != nil {
-			return false, fmt.Errorf("failed to create migrate database: %w", err)
+			return false, err
 		}
+		defer postgresConn.Close(ctx)
 
-		migrateConn, err := pgx.Connect(ctx, postgresInstance.DSN("migrate"))
+		targetConn, migrateConn, err := createGenerateDatabases(ctx, postgresInstance)
 		if err != nil {
-			return false, fmt.Errorf("failed to connect to migrate database: %w", err)
+			return false, err
 		}
+		defer targetConn.Close(ctx)
 		defer migrateConn.Close(ctx)
 
-		statements, err := generateMigrationStatements(
+		migration, err := generateMigrationStatements(
 			ctx,
 			config,
 			wd,
 			tmpDir,
 			migrationsDir,
-			migrationNumber == 1,
+			len(previousMigrationFiles) == 0,
+			postgresInstance,
 			postgresConn,
 			targetConn,
 			migrateConn,
@@ -411,22 +696,23 @@ func runWithFile(
 			return false, fmt.Errorf("failed to generate migration statements: %w", err)
 		}
 
-		//nolint:gosec
-		err = os.WriteFile(
-			newMigrationFilePath,
-			[]byte(statements),
-			0o644,
-		)
+		err = writeModelOutputs(ctx, config, wd, tmpDir)
 		if err != nil {
-			return false, fmt.Errorf("failed to write migration file: %w", err)
+			return false, err
 		}
-		log.Println("Wrote migration file")
 
-		err = internal.RunHook(ctx, wd, "generate-migration-post", &internal.HookOptions{
-			Args: []string{newMigrationFilePath},
-		})
+		err = checkHazards(config, migration.allStatements(), acknowledgedHazards)
+		if err != nil {
+			return false, err
+		}
+
+		err = writeMigrationFiles(ctx, config, wd, newMigrationFilePath, migration)
 		if err != nil {
-			return false, fmt.Errorf("failed to run hook: %w", err)
+			return false, err
+		}
+
+		if migration.hasConcurrentMigration() {
+			migrationNumber = scheme.NextVersion(migrationNumber)
 		}
 
 		err = writeTemplateFiles(config, migrationNumber)
@@ -434,7 +720,7 @@ func runWithFile(
 			return false, fmt.Errorf("failed to write template files: %w", err)
 		}
 
-		if errorOnDiff && statements != "" {
+		if errorOnDiff && (migration.up() != "" || migration.hasConcurrentMigration()) {
 			return true, ErrDiffDetected
 		}
 
@@ -444,20 +730,130 @@ func runWithFile(
 	return false, nil
 }
 
-func checkIfUpdated(config *configuration.Config, wd string) (bool, error) {
-	m, err := os.ReadFile(filepath.Join(wd, fmt.Sprintf("%s.dbm", config.ModelName)))
+// checkHazards applies the hazard policies of the config to the statements.
+// Denied hazards fail unless their type has been acknowledged.
+func checkHazards(config *configuration.Config, statements []diff.Statement, acknowledgedHazards []string) error {
+	denied := false
+	for _, stmt := range statements {
+		for _, hazard := range stmt.Hazards {
+			switch config.GetHazardPolicy(hazard.Type) {
+			case configuration.HazardPolicyAllow:
+			case configuration.HazardPolicyWarn:
+				log.Printf("WARNING: hazard %s in statement %q: %s\n", hazard.Type, stmt.DDL, hazard.Message)
+			case configuration.HazardPolicyDeny:
+				if slices.Contains(acknowledgedHazards, hazard.Type) {
+					log.Printf("Acknowledged hazard %s in statement %q: %s\n", hazard.Type, stmt.DDL, hazard.Message)
+
+					continue
+				}
+				log.Printf("ERROR: denied hazard %s in statement %q: %s\n", hazard.Type, stmt.DDL, hazard.Message)
+				denied = true
+			}
+		}
+	}
+
+	if denied {
+		log.Println("Use --acknowledge-hazards to accept the denied hazards for this migration")
+
+		return ErrDeniedHazards
+	}
+
+	return nil
+}
+
+// writeMigrationFiles writes the up and down migration files and runs the
+// generate-migration-post hook for each of them. Concurrent index operations
+// are written to the next migration. The written files are hashed into the
+// sum file afterwards.
+func writeMigrationFiles(
+	ctx context.Context,
+	config *configuration.Config,
+	wd,
+	upMigrationFilePath string,
+	migration *generatedMigration,
+) error {
+	type migrationFile struct {
+		path       string
+		statements string
+	}
+
+	migrationFiles := []migrationFile{
+		{path: upMigrationFilePath, statements: migration.up()},
+		{path: internal.GetDownMigrationFileName(upMigrationFilePath), statements: migration.down()},
+	}
+	if migration.hasConcurrentMigration() {
+		concurrentMigrationFilePath, err := internal.NewVersionScheme(config).ConcurrentMigrationFileName(upMigrationFilePath)
+		if err != nil {
+			return fmt.Errorf("failed to get concurrent migration file name: %w", err)
+		}
+		migrationFiles = append(
+			migrationFiles,
+			migrationFile{path: concurrentMigrationFilePath, statements: migration.concurrentUp()},
+			migrationFile{
+				path:       internal.GetDownMigrationFileName(concurrentMigrationFilePath),
+				statements: migration.concurrentDown(),
+			},
+		)
+	}
+
+	for _, migrationFile := range migrationFiles {
+		//nolint:gosec
+		err := os.WriteFile(
+			migrationFile.path,
+			[]byte(migrationFile.statements),
+			0o644,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to write migration file: %w", err)
+		}
+		log.Printf("Wrote migration file %q\n", filepath.Base(migrationFile.path))
+
+		err = internal.RunHook(ctx, config, wd, "generate-migration-post", &internal.HookOptions{
+			Args: []string{migrationFile.path},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to run hook: %w", err)
+		}
+	}
+
+	migrationNumber, err := internal.GetMigrationVersion(filepath.Base(upMigrationFilePath))
 	if err != nil {
-		return false, fmt.Errorf("failed to read model file: %w", err)
+		return err //nolint:wrapcheck
+	}
+
+	err = internal.UpdateSum(wd, filepath.Dir(upMigrationFilePath), migrationNumber)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", internal.SumFileName, err)
 	}
-	mStr := strings.TrimSuffix(string(m), "\n")
-	if mStr == "" || mStr == modelContent {
-		return false, nil
+
+	return nil
+}
+
+// generatedMigrationFilePaths returns all files generate may write for the
+// given up migration, including those of the concurrent migration.
+func generatedMigrationFilePaths(scheme internal.VersionScheme, upMigrationFilePath string) ([]string, error) {
+	concurrentMigrationFilePath, err := scheme.ConcurrentMigrationFileName(upMigrationFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get concurrent migration file name: %w", err)
 	}
-	modelContent = mStr
 
-	log.Println("Changes detected")
+	return []string{
+		upMigrationFilePath,
+		internal.GetDownMigrationFileName(upMigrationFilePath),
+		concurrentMigrationFilePath,
+		internal.GetDownMigrationFileName(concurrentMigrationFilePath),
+	}, nil
+}
+
+// isModelEmpty reports whether the model file has no content yet. Nothing is
+// generated for an empty model, which happens while pgModeler saves it.
+func isModelEmpty(config *configuration.Config, wd string) (bool, error) {
+	m, err := os.ReadFile(filepath.Join(wd, fmt.Sprintf("%s.dbm", config.ModelName)))
+	if err != nil {
+		return false, fmt.Errorf("failed to read model file: %w", err)
+	}
 
-	return true, nil
+	return strings.TrimSpace(string(m)) == "", nil
 }
 
 func writeTemplateFiles(config *configuration.Config, newVersion uint) error {
@@ -468,7 +864,7 @@ func writeTemplateFiles(config *configuration.Config, newVersion uint) error {
 			return fmt.Errorf("failed to create %q: %w", dir, err)
 		}
 
-		data, err := internal.ExecuteConfigTemplate(ts, newVersion)
+		data, err := internal.ExecuteConfigTemplate(ts, newVersion+config.VersionOffset)
 		if err != nil {
 			return fmt.Errorf("failed to execute template: %w", err)
 		}
@@ -482,12 +878,8 @@ func writeTemplateFiles(config *configuration.Config, newVersion uint) error {
 	return nil
 }
 
-var (
-	//nolint:gochecknoglobals
-	modelContent = ""
-)
-
-//nolint:cyclop,gocognit
+// generateMigrationStatements generates the statements of a new migration. The
+// generate-pre hook runs against the migrate database before it is diffed.
 func generateMigrationStatements(
 	ctx context.Context,
 	config *configuration.Config,
@@ -495,84 +887,500 @@ func generateMigrationStatements(
 	tmpDir,
 	migrationsDir string,
 	initial bool,
+	postgresInstance postgres.Instance,
 	postgresConn,
 	targetConn,
 	migrateConn *pgx.Conn,
-) (string, error) {
+) (*generatedMigration, error) {
 	log.Println("Generating migration statements")
 
-	dbmPath := filepath.Join(wd, fmt.Sprintf("%s.dbm", config.ModelName))
+	snapshots, err := prepareGenerateDatabases(
+		ctx, config, wd, tmpDir, migrationsDir, initial, postgresInstance, targetConn, migrateConn)
+	if err != nil {
+		return nil, err
+	}
+
+	// The hook runs after the snapshot has been saved, so that its changes are not cached
+	err = internal.RunHook(ctx, config, wd, "generate-pre", &internal.HookOptions{
+		DSN: postgres.DSN(migrateConn, "disable"),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to run hook: %w", err)
+	}
+
+	return diffMigrationStatements(
+		ctx, config, tmpDir, snapshots, migrationsDir, initial, postgresInstance, postgresConn, targetConn, migrateConn)
+}
+
+// prepareGenerateDatabases exports the model into the target database and
+// replays the migrations into the migrate database. It returns the snapshot
+// cache used for the migrations, which is nil if snapshots are disabled.
+func prepareGenerateDatabases(
+	ctx context.Context,
+	config *configuration.Config,
+	wd,
+	tmpDir,
+	migrationsDir string,
+	initial bool,
+	postgresInstance postgres.Instance,
+	targetConn,
+	migrateConn *pgx.Conn,
+) (*postgres.SnapshotCache, error) {
 	// Generate SQL file in tmpDir for internal use during migration generation
-	tmpSQLPath := filepath.Join(tmpDir, fmt.Sprintf("%s.sql", config.ModelName))
+	tmpSQLPath := exportedModelPath(config, tmpDir)
 
-	err := internal.PgmodelerExportSQL(ctx, dbmPath, tmpSQLPath)
+	err := internal.PgmodelerExportSQL(ctx, filepath.Join(wd, fmt.Sprintf("%s.dbm", config.ModelName)), tmpSQLPath)
 	if err != nil {
-		return "", fmt.Errorf("failed to export model: %w", err)
+		return nil, fmt.Errorf("failed to export model: %w", err)
+	}
+
+	for _, role := range config.Roles {
+		err = postgresInstance.CreateRole(ctx, role.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create role %q: %w", role.Name, err)
+		}
 	}
 
-	// Copy SQL to output path if enabled
+	err = executeTargetSQL(ctx, tmpSQLPath, targetConn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute target sql: %w", err)
+	}
+
+	snapshots := newSnapshotCache(config, wd, migrationsDir, false)
+
+	// Apply existing migrations to the migrate database (skip if no migrations exist yet)
+	if !initial {
+		err = executeMigrateSQL(ctx, config, snapshots, migrationsDir, migrateConn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to execute migrate sql: %w", err)
+		}
+	}
+
+	return snapshots, nil
+}
+
+// diffMigrationStatements diffs the migrate database against the target
+// database and generates the statements of the up and down migrations.
+func diffMigrationStatements(
+	ctx context.Context,
+	config *configuration.Config,
+	tmpDir string,
+	snapshots *postgres.SnapshotCache,
+	migrationsDir string,
+	initial bool,
+	postgresInstance postgres.Instance,
+	postgresConn,
+	targetConn,
+	migrateConn *pgx.Conn,
+) (*generatedMigration, error) {
+	diffOptions := []internal.DiffOption{internal.WithTimeouts(config.Timeouts)}
+	if config.ConcurrentIndexOps {
+		diffOptions = append(diffOptions, internal.WithConcurrentIndexOps())
+	}
+
+	// Generate diff between migrate database (with existing migrations) and target database (with full schema)
+	statements, err := internal.DiffStatements(
+		ctx,
+		postgresConn,
+		migrateConn,
+		targetConn,
+		diffOptions...,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to diff: %w", err)
+	}
+
+	migration := &generatedMigration{}
+	migration.statements, migration.concurrentStatements, err = splitConcurrentStatements(ctx, migrateConn, statements)
+	if err != nil {
+		return nil, fmt.Errorf("failed to split concurrent statements: %w", err)
+	}
+
+	// Generate the reverse diff for the down migration. This has to happen before the permission statements are
+	// generated, because that applies the up migration to the migrate database.
+	if len(migration.concurrentStatements) > 0 {
+		err = generateConcurrentDownStatements(
+			ctx, config, snapshots, migrationsDir, initial, postgresInstance, postgresConn, targetConn, migrateConn, migration)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		migration.downStatements, err = internal.DiffStatements(
+			ctx,
+			postgresConn,
+			targetConn,
+			migrateConn,
+			internal.WithTimeouts(config.Timeouts),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to diff down: %w", err)
+		}
+	}
+
+	migration.permissionStatements, migration.permissionDownStatements, err = generateMissingPermissionStatements(
+		ctx,
+		tmpDir,
+		migration.allStatements(),
+		targetConn,
+		migrateConn,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate missing permission statements: %w", err)
+	}
+
+	return migration, nil
+}
+
+// exportedModelPath returns the path generateMigrationStatements exports the model to.
+func exportedModelPath(config *configuration.Config, tmpDir string) string {
+	return filepath.Join(tmpDir, fmt.Sprintf("%s.sql", config.ModelName))
+}
+
+// writeModelOutputs writes the outputs enabled in the config. The SQL output
+// is copied from the model exported by generateMigrationStatements.
+func writeModelOutputs(ctx context.Context, config *configuration.Config, wd, tmpDir string) error {
+	dbmPath := filepath.Join(wd, fmt.Sprintf("%s.dbm", config.ModelName))
+
 	if sqlPath := config.GetOutputPath("sql"); sqlPath != "" {
-		var sqlContent []byte
-		sqlContent, err = os.ReadFile(tmpSQLPath)
+		sqlContent, err := os.ReadFile(exportedModelPath(config, tmpDir))
 		if err != nil {
-			return "", fmt.Errorf("failed to read sql file: %w", err)
+			return fmt.Errorf("failed to read sql file: %w", err)
 		}
 		err = os.WriteFile(filepath.Join(wd, sqlPath), sqlContent, 0o644) //nolint:gosec
 		if err != nil {
-			return "", fmt.Errorf("failed to write sql output file: %w", err)
+			return fmt.Errorf("failed to write sql output file: %w", err)
 		}
 	}
 
 	if pngPath := config.GetOutputPath("png"); pngPath != "" {
-		err = internal.PgmodelerExportPNG(ctx, dbmPath, filepath.Join(wd, pngPath))
+		err := internal.PgmodelerExportPNG(ctx, dbmPath, filepath.Join(wd, pngPath))
 		if err != nil {
-			return "", fmt.Errorf("failed to export png: %w", err)
+			return fmt.Errorf("failed to export png: %w", err)
 		}
 	}
 
 	if svgPath := config.GetOutputPath("svg"); svgPath != "" {
-		err = internal.PgmodelerExportSVG(ctx, dbmPath, filepath.Join(wd, svgPath))
+		err := internal.PgmodelerExportSVG(ctx, dbmPath, filepath.Join(wd, svgPath))
 		if err != nil {
-			return "", fmt.Errorf("failed to export svg: %w", err)
+			return fmt.Errorf("failed to export svg: %w", err)
 		}
 	}
 
-	for _, role := range config.Roles {
-		_, err = targetConn.Exec(ctx, fmt.Sprintf("CREATE ROLE %q WITH LOGIN;", role.Name))
+	return nil
+}
+
+// splitConcurrentStatements moves the concurrent index operations to the
+// statements that run outside a transaction, together with the later
+// statements that refer to one of those indexes or to their tables. That keeps
+// constraints using an index and changes to its columns after the index. All
+// other statements, and statements with dollar-quoted bodies, stay in the
+// transactional migration. A statement that has to run outside the transaction
+// must not contain a semicolon. The tables of dropped indexes are looked up in
+// the database the statements are generated for.
+func splitConcurrentStatements(
+	ctx context.Context,
+	conn *pgx.Conn,
+	statements []diff.Statement,
+) (transactional, concurrent []diff.Statement, err error) {
+	var references []*regexp.Regexp
+	for _, stmt := range statements {
+		var relations []qualifiedName
+		relations, err = concurrentIndexRelations(ctx, conn, stmt.DDL)
 		if err != nil {
-			return "", fmt.Errorf("failed to create role %q: %w", role.Name, err)
+			return nil, nil, err
 		}
+
+		for _, relation := range relations {
+			references = append(references, relation.referencePattern())
+		}
+
+		// golang-migrate splits the statements of the non-transactional migration at every semicolon, so statements
+		// with bodies, such as functions, always stay in the transaction. Their bodies don't depend on indexes.
+		if relations == nil && (regexpDollarQuote.MatchString(stmt.DDL) ||
+			!slices.ContainsFunc(references, func(r *regexp.Regexp) bool { return r.MatchString(stmt.DDL) })) {
+			transactional = append(transactional, stmt)
+
+			continue
+		}
+
+		// The statements come without the trailing semicolon, so any semicolon is part of a string, a quoted
+		// identifier or a comment, which golang-migrate would split the statement at.
+		if strings.Contains(stmt.DDL, ";") {
+			return nil, nil, fmt.Errorf("%w: %q", errSemicolonInConcurrentStatement, stmt.DDL)
+		}
+		concurrent = append(concurrent, stmt)
 	}
 
-	err = executeTargetSQL(ctx, tmpSQLPath, targetConn)
+	return transactional, concurrent, nil
+}
+
+// qualifiedName is the schema and name of a relation, without quotes.
+type qualifiedName struct {
+	schema string
+	name   string
+}
+
+// referencePattern matches the relation in DDL, quoted or not and with or without schema.
+func (n qualifiedName) referencePattern() *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf(`(?:^|[^\w"])(?:"?%s"?\.)?"?%s"?(?:[^\w"]|$)`,
+		regexp.QuoteMeta(n.schema),
+		regexp.QuoteMeta(n.name),
+	))
+}
+
+// concurrentIndexRelations returns the index and the table of a concurrent
+// index operation, or nil if the statement is not one.
+func concurrentIndexRelations(ctx context.Context, conn *pgx.Conn, ddl string) ([]qualifiedName, error) {
+	if match := regexpCreateIndexConcurrently.FindStringSubmatch(ddl); match != nil {
+		table := parseQualifiedName(match[2])
+
+		return []qualifiedName{{schema: table.schema, name: parseQualifiedName(match[1]).name}, table}, nil
+	}
+
+	if match := regexpDropIndexConcurrently.FindStringSubmatch(ddl); match != nil {
+		index := parseQualifiedName(match[1])
+
+		var table string
+		err := conn.QueryRow(
+			ctx,
+			"SELECT tablename FROM pg_indexes WHERE schemaname = $1 AND indexname = $2",
+			index.schema,
+			index.name,
+		).Scan(&table)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return []qualifiedName{index}, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get table of index %q: %w", index.name, err)
+		}
+
+		return []qualifiedName{index, {schema: index.schema, name: table}}, nil
+	}
+
+	if strings.Contains(ddl, " CONCURRENTLY ") {
+		return []qualifiedName{}, nil
+	}
+
+	return nil, nil
+}
+
+// parseQualifiedName parses an optionally schema qualified and quoted name. The schema defaults to public.
+func parseQualifiedName(name string) qualifiedName {
+	var parts []string
+	for _, match := range regexpIdentifier.FindAllStringSubmatch(name, -1) {
+		if match[1] != "" {
+			parts = append(parts, strings.ReplaceAll(match[1], `""`, `"`))
+		} else {
+			parts = append(parts, match[2])
+		}
+	}
+
+	if len(parts) < 2 {
+		return qualifiedName{schema: "public", name: strings.Join(parts, "")}
+	}
+
+	return qualifiedName{schema: parts[len(parts)-2], name: parts[len(parts)-1]}
+}
+
+// generateConcurrentDownStatements generates the down statements of both
+// migrations of a split migration. This replays the migrations into an
+// intermediate database and applies the transactional statements to it, so
+// that each down migration only reverts its own up migration. The migrate
+// database must still be in the state before the migration.
+func generateConcurrentDownStatements(
+	ctx context.Context,
+	config *configuration.Config,
+	snapshots *postgres.SnapshotCache,
+	migrationsDir string,
+	initial bool,
+	postgresInstance postgres.Instance,
+	postgresConn,
+	targetConn,
+	migrateConn *pgx.Conn,
+	migration *generatedMigration,
+) error {
+	err := postgresInstance.CreateDatabase(ctx, "intermediate")
 	if err != nil {
-		return "", fmt.Errorf("failed to execute target sql: %w", err)
+		return fmt.Errorf("failed to create intermediate database: %w", err)
 	}
 
-	// Apply existing migrations to the migrate database (skip if no migrations exist yet)
+	intermediateConn, err := pgx.Connect(ctx, postgresInstance.DSN("intermediate"))
+	if err != nil {
+		return fmt.Errorf("failed to connect to intermediate database: %w", err)
+	}
+	defer intermediateConn.Close(ctx)
+
 	if !initial {
-		err = executeMigrateSQL(migrationsDir, migrateConn)
+		err = executeMigrateSQL(ctx, config, snapshots, migrationsDir, intermediateConn)
 		if err != nil {
-			return "", fmt.Errorf("failed to execute migrate sql: %w", err)
+			return fmt.Errorf("failed to execute migrate sql: %w", err)
 		}
 	}
 
-	// Generate diff between migrate database (with existing migrations) and target database (with full schema)
-	statements, err := internal.Diff(
+	for _, stmt := range migration.statements {
+		_, err = intermediateConn.Exec(ctx, stmt.DDL)
+		if err != nil {
+			return fmt.Errorf("failed to apply statement to intermediate database: %w", err)
+		}
+	}
+
+	migration.downStatements, err = internal.DiffStatements(
 		ctx,
 		postgresConn,
+		intermediateConn,
 		migrateConn,
-		targetConn,
+		internal.WithTimeouts(config.Timeouts),
 	)
 	if err != nil {
-		return "", fmt.Errorf("failed to diff: %w", err)
+		return fmt.Errorf("failed to diff down: %w", err)
 	}
 
-	extraStatements, err := generateMissingPermissionStatements(ctx, tmpDir, statements, targetConn, migrateConn)
+	migration.concurrentDownStatements, err = internal.DiffStatements(
+		ctx,
+		postgresConn,
+		targetConn,
+		intermediateConn,
+		internal.WithTimeouts(config.Timeouts),
+		internal.WithConcurrentIndexOps(),
+	)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate missing permission statements: %w", err)
+		return fmt.Errorf("failed to diff concurrent down: %w", err)
+	}
+
+	return nil
+}
+
+// generatedMigration holds the statements generated for a single migration.
+type generatedMigration struct {
+	// statements are generated by pg-schema-diff.
+	statements []diff.Statement
+	// permissionStatements are generated by comparing dumps of both databases
+	// after all statements have been applied. They go into the concurrent
+	// migration if there is one, because they may refer to objects it creates.
+	permissionStatements []string
+	// permissionDownStatements revert the permission statements. They always go
+	// into the down migration that runs last.
+	permissionDownStatements []string
+	// downStatements revert the migration.
+	downStatements []diff.Statement
+	// concurrentStatements start at the first concurrent index operation and
+	// are written to a separate migration that runs outside a transaction.
+	concurrentStatements []diff.Statement
+	// concurrentDownStatements revert the concurrent migration.
+	concurrentDownStatements []diff.Statement
+}
+
+// allStatements returns the statements generated by pg-schema-diff in order of execution.
+func (g *generatedMigration) allStatements() []diff.Statement {
+	return slices.Concat(g.statements, g.concurrentStatements)
+}
+
+// hasConcurrentMigration reports whether the statements are split into a second migration.
+func (g *generatedMigration) hasConcurrentMigration() bool {
+	return len(g.concurrentStatements) > 0
+}
+
+// up returns the content of the up migration file.
+func (g *generatedMigration) up() string {
+	if g.hasConcurrentMigration() {
+		return joinMigrationStatements(internal.FormatStatements(g.statements), "")
 	}
 
+	return joinMigrationStatements(
+		internal.FormatStatements(g.statements),
+		strings.Join(g.permissionStatements, "\n"),
+	)
+}
+
+// down returns the content of the down migration file.
+func (g *generatedMigration) down() string {
+	return joinMigrationStatements(
+		internal.FormatStatements(g.downStatements),
+		strings.Join(g.permissionDownStatements, "\n"),
+	)
+}
+
+// concurrentUp returns the content of the up migration that runs outside a transaction.
+func (g *generatedMigration) concurrentUp() string {
+	return internal.NoTransactionMarker + "\n\n" + joinMigrationStatements(
+		internal.FormatStatements(g.concurrentStatements),
+		strings.Join(g.permissionStatements, "\n"),
+	)
+}
+
+// concurrentDown returns the content of the down migration that runs outside a transaction.
+func (g *generatedMigration) concurrentDown() string {
+	return internal.NoTransactionMarker + "\n\n" + internal.FormatStatements(g.concurrentDownStatements) + "\n"
+}
+
+const (
+	statementSourcePlan        = "plan"
+	statementSourcePermissions = "permissions"
+)
+
+//nolint:tagliatelle
+type jsonStatement struct {
+	DDL              string                 `json:"ddl"`
+	StatementTimeout int64                  `json:"statement_timeout_ms"`
+	LockTimeout      int64                  `json:"lock_timeout_ms"`
+	Hazards          []diff.MigrationHazard `json:"hazards"`
+	Source           string                 `json:"source"`
+	NoTransaction    bool                   `json:"no_transaction"`
+}
+
+type jsonMigration struct {
+	Statements []jsonStatement `json:"statements"`
+}
+
+// printMigrationJSON writes the statements of the migration as JSON to stdout.
+// Permission statements run with the timeouts of the last plan statement.
+func printMigrationJSON(migration *generatedMigration) error {
+	output := jsonMigration{Statements: []jsonStatement{}}
+
+	var statementTimeout, lockTimeout int64
+	appendStatements := func(statements []diff.Statement, noTransaction bool) {
+		for _, stmt := range statements {
+			statementTimeout = stmt.Timeout.Milliseconds()
+			lockTimeout = stmt.LockTimeout.Milliseconds()
+			hazards := stmt.Hazards
+			if hazards == nil {
+				hazards = []diff.MigrationHazard{}
+			}
+			output.Statements = append(output.Statements, jsonStatement{
+				DDL:              stmt.DDL,
+				StatementTimeout: statementTimeout,
+				LockTimeout:      lockTimeout,
+				Hazards:          hazards,
+				Source:           statementSourcePlan,
+				NoTransaction:    noTransaction,
+			})
+		}
+	}
+
+	appendStatements(migration.statements, false)
+	appendStatements(migration.concurrentStatements, true)
+
+	for _, stmt := range migration.permissionStatements {
+		output.Statements = append(output.Statements, jsonStatement{
+			DDL:              strings.TrimSuffix(stmt, ";"),
+			StatementTimeout: statementTimeout,
+			LockTimeout:      lockTimeout,
+			Hazards:          []diff.MigrationHazard{},
+			Source:           statementSourcePermissions,
+			NoTransaction:    migration.hasConcurrentMigration(),
+		})
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+
+	//nolint:wrapcheck
+	return encoder.Encode(output)
+}
+
+// joinMigrationStatements combines the statements of the diff with the
+// statements generated by the permission pass into the content of a migration file.
+func joinMigrationStatements(statements, extraStatements string) string {
 	var output string
 	if statements != "" {
 		output += statements
@@ -587,22 +1395,101 @@ func generateMigrationStatements(
 		output += "\n"
 	}
 
-	return output, nil
+	return output
 }
 
-func executeMigrateSQL(migrationsDir string, migrateConn *pgx.Conn) error {
-	m, err := migrate.New(fmt.Sprintf("file://%s", migrationsDir), postgres.DSN(migrateConn, "disable"))
+// executeMigrateSQL replays the migrations into the empty database. If a
+// snapshot cache is given, the newest valid snapshot is restored first and a
+// new snapshot is saved afterwards.
+func executeMigrateSQL(
+	ctx context.Context,
+	config *configuration.Config,
+	snapshots *postgres.SnapshotCache,
+	migrationsDir string,
+	migrateConn *pgx.Conn,
+) error {
+	return executeMigrateSQLUpTo(ctx, config, snapshots, migrationsDir, 0, migrateConn)
+}
+
+// executeMigrateSQLUpTo replays the migrations up to and including the given
+// version into the empty database, like executeMigrateSQL. Version 0 replays
+// all migrations.
+func executeMigrateSQLUpTo(
+	ctx context.Context,
+	config *configuration.Config,
+	snapshots *postgres.SnapshotCache,
+	migrationsDir string,
+	version uint,
+	migrateConn *pgx.Conn,
+) error {
+	migrationFiles, err := internal.FindMigrations(migrationsDir, true)
+	if err != nil {
+		return fmt.Errorf("failed to find migrations: %w", err)
+	}
+
+	migrationFiles, err = internal.MigrationsUpTo(migrationFiles, version)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	dsn := postgres.DSN(migrateConn, "disable")
+
+	var restored uint
+	if snapshots != nil {
+		restored, err = snapshots.Restore(ctx, dsn, migrationFiles)
+		if err != nil {
+			return fmt.Errorf("failed to restore snapshot: %w", err)
+		}
+		if restored > 0 {
+			log.Printf("Restored snapshot after migration %d of %d\n", restored, len(migrationFiles))
+		}
+	}
+
+	m, err := internal.NewMigrator(migrationsDir, dsn, config.VersionOffset)
 	if err != nil {
 		return fmt.Errorf("failed to create migrate: %w", err)
 	}
-	err = m.Up()
+	defer m.Close() //nolint:errcheck
+
+	err = m.Up(migrationFiles)
+	if errors.Is(err, migrate.ErrNoChange) && restored > 0 {
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("failed to up migrations: %w", err)
 	}
 
+	if snapshots != nil {
+		saveSnapshot(ctx, snapshots, dsn, migrationFiles)
+	}
+
 	return nil
 }
 
+// newSnapshotCache returns the snapshot cache of the project, or nil if it is disabled or unavailable.
+func newSnapshotCache(config *configuration.Config, wd, migrationsDir string, testdata bool) *postgres.SnapshotCache {
+	if !config.SnapshotsEnabled() {
+		return nil
+	}
+
+	snapshots, err := postgres.NewSnapshotCache(wd, migrationsDir, config.RoleNames(), config.VersionOffset, testdata)
+	if err != nil {
+		log.Printf("WARNING: snapshot cache is unavailable: %v\n", err)
+
+		return nil
+	}
+
+	return snapshots
+}
+
+// saveSnapshot saves a snapshot of the database. A failure only costs time on the next run, so it is only logged.
+func saveSnapshot(ctx context.Context, snapshots *postgres.SnapshotCache, dsn string, migrationFiles []string) {
+	err := snapshots.Save(ctx, dsn, migrationFiles)
+	if err != nil {
+		log.Printf("WARNING: failed to save snapshot: %v\n", err)
+	}
+}
+
 func executeTargetSQL(ctx context.Context, sqlPath string, targetConn *pgx.Conn) error {
 	targetSQL, err := os.ReadFile(sqlPath)
 	if err != nil {
@@ -619,49 +1506,115 @@ func executeTargetSQL(ctx context.Context, sqlPath string, targetConn *pgx.Conn)
 
 // generateMissingPermissionStatements generates missing permission statements
 // for the given target and migration connections. This feature is not yet
-// available in pg-schema-diff, but planned.
+// available in pg-schema-diff, but planned. It applies the statements to the
+// migrate database and returns the permission statements of the up migration
+// and the statements that revert them in the down migration.
 //
 // nolint:godox
 // TODO: This function should probably be moved to the internal package.
 func generateMissingPermissionStatements(
 	ctx context.Context,
-	tmpDir,
-	statements string,
+	tmpDir string,
+	statements []diff.Statement,
 	targetConn,
 	migrateConn *pgx.Conn,
-) (string, error) {
-	_, err := migrateConn.Exec(ctx, statements)
-	if err != nil {
-		return "", fmt.Errorf("failed to apply generated migration: %w", err)
-	}
-
+) (up, down []string, err error) {
 	pgDumpOptions := []string{
 		"--schema-only",
 		"--exclude-table=public.schema_migrations",
 	}
 
+	previousDump, err := postgres.PgDump(ctx, postgres.DSN(migrateConn, "disable"), pgDumpOptions)
+	if err != nil {
+		//nolint:wrapcheck
+		return nil, nil, err
+	}
+
+	// Statements are applied one by one, because concurrent index operations can't run in a transaction
+	for _, stmt := range statements {
+		_, err = migrateConn.Exec(ctx, stmt.DDL)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to apply generated migration: %w", err)
+		}
+	}
+
 	targetDump, err := postgres.PgDump(ctx, postgres.DSN(targetConn, "disable"), pgDumpOptions)
 	if err != nil {
 		//nolint:wrapcheck
-		return "", err
+		return nil, nil, err
 	}
 
 	migrateDump, err := postgres.PgDump(ctx, postgres.DSN(migrateConn, "disable"), pgDumpOptions)
 	if err != nil {
 		//nolint:wrapcheck
-		return "", err
+		return nil, nil, err
+	}
+
+	up, err = diffDumpLines(ctx, tmpDir, migrateDump, targetDump)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	restored, err := diffDumpLines(ctx, tmpDir, targetDump, previousDump)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var superuser string
+	err = migrateConn.QueryRow(ctx, "SELECT current_user").Scan(&superuser)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get current user: %w", err)
 	}
 
-	targetDumpFile := filepath.Join(tmpDir, "target.sql")
-	err = os.WriteFile(targetDumpFile, []byte(targetDump), 0o600)
+	return up, revertPermissionStatements(up, restored, targetDump, superuser), nil
+}
+
+// revertPermissionStatements returns the statements of the down migration that
+// revert the permission statements of the up migration. The restored lines are
+// the permission lines of the database before the migration that the target
+// doesn't have. Objects owned by the superuser of the instance are owned by
+// whoever runs the migrations.
+func revertPermissionStatements(up, restored []string, targetDump, superuser string) []string {
+	var down []string
+	for _, line := range up {
+		if match := regexpDefaultPrivilegeGrant.FindStringSubmatch(line); match != nil {
+			down = append(down, fmt.Sprintf("%s REVOKE %s FROM %s;", match[1], match[2], match[3]))
+		}
+	}
+
+	for _, line := range restored {
+		object, owner, isOwner := strings.Cut(strings.TrimSuffix(line, ";"), " OWNER TO ")
+		if !isOwner {
+			down = append(down, line)
+
+			continue
+		}
+		if owner != superuser && owner != strconv.Quote(superuser) {
+			down = append(down, line)
+
+			continue
+		}
+		// Objects that the down migration creates again are owned by whoever runs it already
+		if strings.Contains(targetDump, "\n"+object+" OWNER TO ") {
+			down = append(down, object+" OWNER TO CURRENT_USER;")
+		}
+	}
+
+	return down
+}
+
+// diffDumpLines returns the permission lines of the new dump that the old dump doesn't have.
+func diffDumpLines(ctx context.Context, tmpDir, oldDump, newDump string) ([]string, error) {
+	oldDumpFile := filepath.Join(tmpDir, "old.sql")
+	err := os.WriteFile(oldDumpFile, []byte(oldDump), 0o600)
 	if err != nil {
-		return "", fmt.Errorf("failed to write target.sql file: %w", err)
+		return nil, fmt.Errorf("failed to write old.sql file: %w", err)
 	}
 
-	migrateDumpFile := filepath.Join(tmpDir, "migrate.sql")
-	err = os.WriteFile(migrateDumpFile, []byte(migrateDump), 0o600)
+	newDumpFile := filepath.Join(tmpDir, "new.sql")
+	err = os.WriteFile(newDumpFile, []byte(newDump), 0o600)
 	if err != nil {
-		return "", fmt.Errorf("failed to write migrate.sql file: %w", err)
+		return nil, fmt.Errorf("failed to write new.sql file: %w", err)
 	}
 
 	diffCmd := exec.CommandContext(
@@ -671,8 +1624,8 @@ func generateMissingPermissionStatements(
 		"--unchanged-line-format=",
 		"--old-line-format=",
 		"--new-line-format=%L",
-		migrateDumpFile,
-		targetDumpFile,
+		oldDumpFile,
+		newDumpFile,
 	)
 	diffCmd.Stderr = os.Stderr
 
@@ -680,7 +1633,7 @@ func generateMissingPermissionStatements(
 	if err != nil {
 		var ee *exec.ExitError
 		if !errors.As(err, &ee) || ee.ExitCode() == 0 {
-			return "", fmt.Errorf("failed to run diff: %w %s", err, string(output))
+			return nil, fmt.Errorf("failed to run diff: %w %s", err, string(output))
 		}
 	}
 
@@ -691,5 +1644,5 @@ func generateMissingPermissionStatements(
 		}
 	}
 
-	return strings.Join(lines, "\n"), nil
+	return lines, nil
 }
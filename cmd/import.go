@@ -0,0 +1,429 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/cobra"
+
+	"github.com/printeers/trek/internal"
+	"github.com/printeers/trek/internal/configuration"
+	"github.com/printeers/trek/internal/postgres"
+	"github.com/printeers/trek/internal/psql"
+)
+
+const importDatabase = "import"
+
+var (
+	errImportSource  = errors.New("exactly one of --from and --from-sql is required")
+	errProjectExists = errors.New("the working directory already has a trek.yaml")
+	errInvalidRoles  = errors.New("invalid role names")
+)
+
+//nolint:gocognit,cyclop
+func NewImportCommand() *cobra.Command {
+	var (
+		version      string
+		from         string
+		fromSQL      string
+		modelName    string
+		databaseName string
+		roleNames    string
+
+		devPostgresFlags internal.DevPostgresFlags
+	)
+
+	importCmd := &cobra.Command{
+		Use:   "import",
+		Short: "Create a new trek project from an existing database",
+		PersistentPreRun: func(cmd *cobra.Command, _ []string) {
+			internal.InitializeFlags(cmd)
+		},
+		Args: func(_ *cobra.Command, _ []string) error {
+			if (from == "") == (fromSQL == "") {
+				return errImportSource
+			}
+			if err := validateModelName(modelName); err != nil {
+				return fmt.Errorf("invalid model name %q: %w", modelName, err)
+			}
+			if err := validateDatabaseName(databaseName); err != nil {
+				return fmt.Errorf("invalid database name %q: %w", databaseName, err)
+			}
+			if roleNames != "" {
+				if err := validateRoles(roleNames); err != nil {
+					return fmt.Errorf("invalid roles %q: %w", roleNames, err)
+				}
+			}
+
+			return nil
+		},
+		RunE: func(_ *cobra.Command, _ []string) error {
+			ctx := context.Background()
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("failed to get working directory: %w", err)
+			}
+
+			if _, err = os.Stat(filepath.Join(wd, configuration.FileName)); err == nil {
+				return errProjectExists
+			}
+
+			tmpDir, err := os.MkdirTemp("", "trek-")
+			if err != nil {
+				return fmt.Errorf("failed to create temporary directory: %w", err)
+			}
+			defer os.RemoveAll(tmpDir) //nolint:errcheck
+
+			// There is no config yet, the instance is configured by the flags only
+			postgresInstance, err := setupPostgresInstance(&configuration.Config{}, &devPostgresFlags)
+			if err != nil {
+				return fmt.Errorf("failed to setup instance: %w", err)
+			}
+			defer postgresInstance.Stop() //nolint:errcheck
+
+			sqlFile := fromSQL
+			var roles []string
+			if roleNames != "" {
+				roles = strings.Split(roleNames, ",")
+			}
+			if from != "" {
+				sqlFile = filepath.Join(tmpDir, "schema.sql")
+				roles, err = dumpImportSource(ctx, from, sqlFile)
+				if err != nil {
+					return err
+				}
+			}
+
+			model, roles, err := importModel(ctx, tmpDir, sqlFile, databaseName, roles, postgresInstance)
+			if err != nil {
+				return err
+			}
+
+			if version == "" {
+				version = "latest"
+			}
+
+			err = writeProjectFiles(wd, map[string]any{
+				"trek_version": version,
+				"model_name":   modelName,
+				"db_name":      databaseName,
+				"roleNames":    roles,
+			}, model)
+			if err != nil {
+				return err
+			}
+
+			log.Println("New project created from the imported database!")
+
+			config, err := configuration.ReadConfig(wd)
+			if err != nil {
+				return fmt.Errorf("failed to read config: %w", err)
+			}
+
+			migrationsDir, err := internal.GetMigrationsDir(wd)
+			if err != nil {
+				return fmt.Errorf("failed to get migrations directory: %w", err)
+			}
+
+			scheme := internal.NewVersionScheme(config)
+			firstVersion := scheme.FirstVersion()
+
+			_, err = runWithFile(
+				ctx,
+				config,
+				wd,
+				tmpDir,
+				migrationsDir,
+				filepath.Join(migrationsDir, scheme.MigrationFileName(firstVersion, "init")),
+				firstVersion,
+				false,
+				nil,
+				postgresInstance,
+			)
+			if err != nil {
+				return fmt.Errorf("failed to generate first migration: %w", err)
+			}
+
+			log.Println("Run trek check to verify the imported project")
+
+			return nil
+		},
+	}
+
+	importCmd.Flags().StringVar(&from, "from", "", "DSN of the database to import")
+	importCmd.Flags().StringVar(&fromSQL, "from-sql", "", "SQL file with the schema to import")
+	importCmd.Flags().StringVar(&version, "version", "", "Trek version to use (in the Dockerfile)")
+	importCmd.Flags().StringVar(&modelName, "model-name", "", "Model (file) name")
+	importCmd.Flags().StringVar(&databaseName, "database-name", "", "Database name")
+	importCmd.Flags().StringVar(&roleNames, "roles", "", "Roles the SQL file refers to, --from uses the roles of the server") //nolint:lll
+	internal.MarkFlagRequired(importCmd, "model-name")
+	internal.MarkFlagRequired(importCmd, "database-name")
+	devPostgresFlags.Register(importCmd)
+
+	return importCmd
+}
+
+// dumpImportSource writes a schema-only dump of the database to the file and
+// returns the roles of its server.
+func dumpImportSource(ctx context.Context, dsn, file string) ([]string, error) {
+	log.Println("Dumping the schema of the database")
+
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	roles, err := postgres.GetRoles(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get roles: %w", err)
+	}
+
+	dump, err := postgres.PgDump(ctx, dsn, []string{
+		"--schema-only",
+		"--exclude-table=public.schema_migrations",
+	})
+	if err != nil {
+		//nolint:wrapcheck
+		return nil, err
+	}
+
+	err = os.WriteFile(file, []byte(dump), 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write dump: %w", err)
+	}
+
+	return roles, nil
+}
+
+// importModel loads the SQL file into the instance and creates a model of it
+// with pgModeler. It returns the model and the roles the model refers to.
+func importModel(
+	ctx context.Context,
+	tmpDir,
+	sqlFile,
+	databaseName string,
+	roles []string,
+	postgresInstance postgres.Instance,
+) ([]byte, []string, error) {
+	for _, role := range roles {
+		err := postgresInstance.CreateRole(ctx, role)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create role %q: %w", role, err)
+		}
+	}
+
+	err := postgresInstance.CreateDatabase(ctx, importDatabase)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create %s database: %w", importDatabase, err)
+	}
+
+	dsn := postgresInstance.DSN(importDatabase)
+
+	log.Println("Loading the schema")
+
+	err = psql.RunFile(ctx, dsn, sqlFile, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load schema: %w", err)
+	}
+
+	log.Println("Importing the database with pgModeler")
+
+	modelFile := filepath.Join(tmpDir, "import.dbm")
+	err = internal.PgmodelerImportDB(ctx, dsn, modelFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to import database: %w", err)
+	}
+
+	model, err := os.ReadFile(modelFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read model: %w", err)
+	}
+
+	// Only the roles the model refers to end up in the config
+	modelRoles, err := modelRoleNames(model)
+	if err != nil {
+		return nil, nil, err
+	}
+	var usedRoles []string
+	for _, role := range modelRoles {
+		if slices.Contains(roles, role) && !slices.Contains(usedRoles, role) {
+			usedRoles = append(usedRoles, role)
+		}
+	}
+	slices.Sort(usedRoles)
+
+	if !configuration.ValidateIdentifierList(usedRoles) {
+		return nil, nil, fmt.Errorf("%w: %q must only contain a-z and _", errInvalidRoles, usedRoles)
+	}
+
+	model, err = prepareImportedModel(model, databaseName, usedRoles)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return model, usedRoles, nil
+}
+
+// modelRoleNames returns the names of all role elements of the model, the
+// definitions of the roles as well as the references to them.
+func modelRoleNames(model []byte) ([]string, error) {
+	var names []string
+	decoder := xml.NewDecoder(bytes.NewReader(model))
+	for {
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			return names, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse model: %w", err)
+		}
+
+		if element, ok := token.(xml.StartElement); ok && element.Name.Local == "role" {
+			names = append(names, xmlAttr(element, "name"))
+		}
+	}
+}
+
+// modelEdit replaces the bytes from start to end of the model.
+type modelEdit struct {
+	start, end  int64
+	replacement string
+}
+
+// prepareImportedModel makes the imported model look like a model created by
+// trek init: the database has the configured name, the database, the public
+// schema and the roles are sql-disabled, and only the configured roles are
+// defined and used as owners. The elements are found by parsing the model, the
+// rest of it is kept as pgModeler wrote it.
+//
+//nolint:cyclop
+func prepareImportedModel(model []byte, databaseName string, roles []string) ([]byte, error) {
+	var edits []modelEdit
+	var depth int
+	// removedDepth is the depth of the element that is being removed, 0 if none
+	var removedDepth int
+	// whitespaceStart is the offset of the whitespace before the current token, -1 if there is none
+	whitespaceStart := int64(-1)
+
+	decoder := xml.NewDecoder(bytes.NewReader(model))
+	for {
+		start := decoder.InputOffset()
+		token, err := decoder.RawToken()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse model: %w", err)
+		}
+		end := decoder.InputOffset()
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if removedDepth > 0 {
+				continue
+			}
+
+			selfClosing := bytes.HasSuffix(model[start:end], []byte("/>"))
+			switch {
+			case depth == 1 && t.Name.Local == "dbmodel":
+				var roleElements strings.Builder
+				for _, role := range roles {
+					roleElements.WriteString(fmt.Sprintf("\n<role name=%q sql-disabled=\"true\" />", role))
+				}
+				edits = append(edits, modelEdit{start: end, end: end, replacement: roleElements.String()})
+			case t.Name.Local == "role" && (depth == 2 || !slices.Contains(roles, xmlAttr(t, "name"))):
+				// Objects owned by other roles, such as the superuser, are owned by whoever runs the migrations
+				removedDepth = depth
+				if whitespaceStart != -1 {
+					start = whitespaceStart
+				}
+				edits = append(edits, modelEdit{start: start, end: end})
+			case depth == 2 && t.Name.Local == "database":
+				t = setXMLAttr(t, "name", databaseName)
+				t = setXMLAttr(t, "sql-disabled", "true")
+				edits = append(edits, modelEdit{start: start, end: end, replacement: xmlStartTag(t, selfClosing)})
+			case depth == 2 && t.Name.Local == "schema" && xmlAttr(t, "name") == "public":
+				t = setXMLAttr(t, "sql-disabled", "true")
+				edits = append(edits, modelEdit{start: start, end: end, replacement: xmlStartTag(t, selfClosing)})
+			}
+		case xml.EndElement:
+			if removedDepth == depth {
+				edits[len(edits)-1].end = end
+				removedDepth = 0
+			}
+			depth--
+		}
+
+		whitespaceStart = -1
+		if data, ok := token.(xml.CharData); ok && len(bytes.TrimSpace(data)) == 0 {
+			whitespaceStart = start
+		}
+	}
+
+	var content bytes.Buffer
+	var offset int64
+	for _, edit := range edits {
+		content.Write(model[offset:edit.start])
+		content.WriteString(edit.replacement)
+		offset = edit.end
+	}
+	content.Write(model[offset:])
+
+	return content.Bytes(), nil
+}
+
+// xmlAttr returns the value of the attribute of the element, "" if it has none.
+func xmlAttr(element xml.StartElement, name string) string {
+	for _, attr := range element.Attr {
+		if attr.Name.Local == name {
+			return attr.Value
+		}
+	}
+
+	return ""
+}
+
+// setXMLAttr sets the attribute of the element, it is added if the element doesn't have it yet.
+func setXMLAttr(element xml.StartElement, name, value string) xml.StartElement {
+	element = element.Copy()
+	for i, attr := range element.Attr {
+		if attr.Name.Local == name {
+			element.Attr[i].Value = value
+
+			return element
+		}
+	}
+	element.Attr = append(element.Attr, xml.Attr{Name: xml.Name{Local: name}, Value: value})
+
+	return element
+}
+
+// xmlStartTag renders the start tag of the element.
+func xmlStartTag(element xml.StartElement, selfClosing bool) string {
+	var tag bytes.Buffer
+	tag.WriteString("<" + element.Name.Local)
+	for _, attr := range element.Attr {
+		tag.WriteString(" " + attr.Name.Local + `="`)
+		_ = xml.EscapeText(&tag, []byte(attr.Value))
+		tag.WriteString(`"`)
+	}
+	if selfClosing {
+		tag.WriteString("/")
+	}
+	tag.WriteString(">")
+
+	return tag.String()
+}
@@ -29,6 +29,8 @@ func NewInitCommand() *cobra.Command {
 		modelName    string
 		databaseName string
 		roleNames    string
+
+		devPostgresFlags internal.DevPostgresFlags
 	)
 	initCmd := &cobra.Command{
 		Use:   "init",
@@ -111,39 +113,9 @@ func NewInitCommand() *cobra.Command {
 				"roleNames":    strings.Split(roleNames, ","),
 			}
 
-			for file, tmpl := range map[string]string{
-				fmt.Sprintf("%s.dbm", modelName): templates.DbmTmpl,
-				"docker-compose.yaml":            templates.DockerComposeYamlTmpl,
-				"Dockerfile":                     templates.DockerfileTmpl,
-				"trek.yaml":                      templates.TrekYamlTmpl,
-			} {
-				err = writeTemplateFile(tmpl, file, templateData)
-				if err != nil {
-					return fmt.Errorf("failed to write %q: %w", file, err)
-				}
-			}
-
-			for _, dir := range []string{"migrations", "testdata", "hooks"} {
-				err = os.MkdirAll(dir, 0o755)
-				if err != nil {
-					return fmt.Errorf("failed to create directory %q: %w", dir, err)
-				}
-			}
-
-			_, err = os.Create(filepath.Join(wd, "testdata", "001_0101-content.sql"))
+			err = writeProjectFiles(wd, templateData, nil)
 			if err != nil {
-				return fmt.Errorf("failed to create testdata file: %w", err)
-			}
-
-			for name, args := range map[string][]string{
-				"apply-reset-pre":         {},
-				"apply-reset-post":        {},
-				"generate-migration-post": {"echo \"Running on migration file $1\""},
-			} {
-				err = writeSampleHook(wd, name, args...)
-				if err != nil {
-					return fmt.Errorf("failed to write hook %q: %w", name, err)
-				}
+				return err
 			}
 
 			log.Println("New project created!")
@@ -163,6 +135,12 @@ func NewInitCommand() *cobra.Command {
 				return fmt.Errorf("failed to create temporary directory: %w", err)
 			}
 
+			postgresInstance, err := setupPostgresInstance(config, &devPostgresFlags)
+			if err != nil {
+				return fmt.Errorf("failed to setup instance: %w", err)
+			}
+			defer postgresInstance.Stop() //nolint:errcheck
+
 			_, err = runWithFile(
 				ctx,
 				config,
@@ -172,6 +150,8 @@ func NewInitCommand() *cobra.Command {
 				filepath.Join(migrationsDir, "001_init.up.sql"),
 				1,
 				false,
+				nil,
+				postgresInstance,
 			)
 			if err != nil {
 				return fmt.Errorf("failed to generate first migration: %w", err)
@@ -186,10 +166,66 @@ func NewInitCommand() *cobra.Command {
 	initCmd.Flags().StringVar(&modelName, "model-name", "", "Model (file) name")
 	initCmd.Flags().StringVar(&databaseName, "database-name", "", "Database name")
 	initCmd.Flags().StringVar(&roleNames, "roles", "", "Roles")
+	devPostgresFlags.Register(initCmd)
 
 	return initCmd
 }
 
+// writeProjectFiles creates the files and directories of a new project. The
+// model is written from the template unless it is given.
+func writeProjectFiles(wd string, templateData map[string]any, model []byte) error {
+	modelFile := fmt.Sprintf("%s.dbm", templateData["model_name"])
+
+	files := map[string]string{
+		"docker-compose.yaml": templates.DockerComposeYamlTmpl,
+		"Dockerfile":          templates.DockerfileTmpl,
+		"trek.yaml":           templates.TrekYamlTmpl,
+	}
+	if model == nil {
+		files[modelFile] = templates.DbmTmpl
+	} else {
+		err := os.WriteFile(filepath.Join(wd, modelFile), model, 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to write %q: %w", modelFile, err)
+		}
+	}
+
+	for file, tmpl := range files {
+		err := writeTemplateFile(tmpl, file, templateData)
+		if err != nil {
+			return fmt.Errorf("failed to write %q: %w", file, err)
+		}
+	}
+
+	for _, dir := range []string{"migrations", "testdata", "hooks"} {
+		err := os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return fmt.Errorf("failed to create directory %q: %w", dir, err)
+		}
+	}
+
+	_, err := os.Create(filepath.Join(wd, "testdata", "001_0101-content.sql"))
+	if err != nil {
+		return fmt.Errorf("failed to create testdata file: %w", err)
+	}
+
+	for name, args := range map[string][]string{
+		"apply-reset-pre":         {},
+		"apply-reset-post":        {},
+		"generate-migration-post": {"echo \"Running on migration file $1\""},
+		"migration-pre": {
+			"echo \"Running before $TREK_MIGRATION_DIRECTION migration $TREK_MIGRATION_FILE (version $TREK_MIGRATION_VERSION)\"",
+		},
+	} {
+		err = writeSampleHook(wd, name, args...)
+		if err != nil {
+			return fmt.Errorf("failed to write hook %q: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
 func validateModelName(s string) error {
 	if !configuration.ValidateIdentifier(s) {
 		return errInvalidModelName
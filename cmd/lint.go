@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/printeers/trek/internal"
+	"github.com/printeers/trek/internal/configuration"
+	"github.com/printeers/trek/internal/dbm"
+	"github.com/printeers/trek/internal/lint"
+)
+
+var errLintFailed = errors.New("lint found errors")
+
+func NewLintCommand() *cobra.Command {
+	var listRules bool
+
+	lintCmd := &cobra.Command{
+		Use:   "lint",
+		Short: "Check the model against the lint rules",
+		PersistentPreRun: func(cmd *cobra.Command, _ []string) {
+			internal.InitializeFlags(cmd)
+		},
+		RunE: func(_ *cobra.Command, _ []string) error {
+			if listRules {
+				for _, rule := range lint.Rules() {
+					fmt.Printf("%s (default %s): %s\n", rule.ID, rule.DefaultSeverity, rule.Description)
+				}
+
+				return nil
+			}
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("failed to get working directory: %w", err)
+			}
+
+			config, err := configuration.ReadConfig(wd)
+			if err != nil {
+				return fmt.Errorf("failed to read config: %w", err)
+			}
+
+			migrationsDir, err := internal.GetMigrationsDir(wd)
+			if err != nil {
+				return fmt.Errorf("failed to get migrations directory: %w", err)
+			}
+
+			migrationFiles, err := internal.FindMigrations(migrationsDir, false)
+			if err != nil {
+				return fmt.Errorf("failed to find migrations: %w", err)
+			}
+
+			modelFindings, err := lintModel(config, wd)
+			if err != nil {
+				return err
+			}
+
+			migrationFindings, err := lintMigrations(config, wd, migrationsDir, migrationFiles)
+			if err != nil {
+				return err
+			}
+
+			return reportFindings(append(modelFindings, migrationFindings...))
+		},
+	}
+
+	lintCmd.Flags().BoolVar(&listRules, "list-rules", false, "List the available rules and their default severity")
+
+	return lintCmd
+}
+
+// lintModel returns the findings of the model rules.
+func lintModel(config *configuration.Config, wd string) ([]lint.Finding, error) {
+	model, err := dbm.ReadFile(filepath.Join(wd, fmt.Sprintf("%s.dbm", config.ModelName)))
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	return lint.Model(config, model), nil
+}
+
+// lintMigrations returns the findings of the migration rules in the up migrations.
+func lintMigrations(
+	config *configuration.Config,
+	wd,
+	migrationsDir string,
+	migrationFiles []string,
+) ([]lint.Finding, error) {
+	var findings []lint.Finding
+	for _, file := range migrationFiles {
+		path := filepath.Join(migrationsDir, file)
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration file: %w", err)
+		}
+
+		relativePath, err := filepath.Rel(wd, path)
+		if err != nil {
+			relativePath = path
+		}
+
+		findings = append(findings, lint.Migration(config, relativePath, string(content))...)
+	}
+
+	return findings, nil
+}
+
+// reportFindings prints the findings and fails if any of them is an error.
+func reportFindings(findings []lint.Finding) error {
+	for _, finding := range findings {
+		log.Println(finding)
+	}
+
+	if lint.HasErrors(findings) {
+		return errLintFailed
+	}
+
+	return nil
+}
@@ -0,0 +1,348 @@
+package cmd
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/printeers/trek/internal"
+	"github.com/printeers/trek/internal/configuration"
+)
+
+//nolint:cyclop
+func NewRebaseCommand() *cobra.Command {
+	var (
+		onto    string
+		hazards []string
+
+		devPostgresFlags internal.DevPostgresFlags
+	)
+
+	rebaseCmd := &cobra.Command{
+		Use:   "rebase",
+		Short: "Move the migrations that are not merged yet after the latest merged migration",
+		PersistentPreRun: func(cmd *cobra.Command, _ []string) {
+			internal.InitializeFlags(cmd)
+		},
+		RunE: func(_ *cobra.Command, _ []string) error {
+			ctx := context.Background()
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("failed to get working directory: %w", err)
+			}
+
+			config, err := configuration.ReadConfig(wd)
+			if err != nil {
+				return fmt.Errorf("failed to read config: %w", err)
+			}
+
+			migrationsDir, err := internal.GetMigrationsDir(wd)
+			if err != nil {
+				return fmt.Errorf("failed to get migrations directory: %w", err)
+			}
+
+			// Not strict, the migrations of both branches may share a version
+			migrationFiles, err := internal.FindMigrations(migrationsDir, false)
+			if err != nil {
+				return fmt.Errorf("failed to find migrations: %w", err)
+			}
+
+			mergedFiles, err := internal.GitListFiles(ctx, wd, onto, "migrations")
+			if err != nil {
+				return fmt.Errorf("failed to list migrations of %s: %w", onto, err)
+			}
+
+			localFiles, latestVersion, err := findLocalMigrations(migrationFiles, mergedFiles)
+			if err != nil {
+				return err
+			}
+			if len(localFiles) == 0 {
+				log.Printf("All migrations are merged into %s, nothing to rebase\n", onto)
+
+				return nil
+			}
+
+			mergedTestdata, err := internal.GitListFiles(ctx, wd, onto, "testdata")
+			if err != nil {
+				return fmt.Errorf("failed to list testdata of %s: %w", onto, err)
+			}
+
+			scheme := internal.NewVersionScheme(config)
+
+			localFiles, err = renumberLocalMigrations(scheme, wd, migrationsDir, localFiles, mergedTestdata, latestVersion)
+			if err != nil {
+				return err
+			}
+
+			firstLocalVersion, err := internal.GetMigrationVersion(localFiles[0])
+			if err != nil {
+				return err //nolint:wrapcheck
+			}
+
+			err = restoreSum(ctx, wd, migrationsDir, onto, firstLocalVersion)
+			if err != nil {
+				return err
+			}
+
+			postgresInstance, err := setupPostgresInstance(config, &devPostgresFlags)
+			if err != nil {
+				return fmt.Errorf("failed to setup instance: %w", err)
+			}
+			defer postgresInstance.Stop() //nolint:errcheck
+
+			tmpDir, err := os.MkdirTemp("", "trek-")
+			if err != nil {
+				return fmt.Errorf("failed to create temporary directory: %w", err)
+			}
+			defer os.RemoveAll(tmpDir) //nolint:errcheck
+
+			// Only the final model is known, so only the last migration can be regenerated. The earlier ones are
+			// replayed as they are.
+			regenerateFile := localFiles[len(localFiles)-1]
+			if len(localFiles) > 1 {
+				var concurrentFile string
+				concurrentFile, err = scheme.ConcurrentMigrationFileName(localFiles[len(localFiles)-2])
+				if err != nil {
+					return fmt.Errorf("failed to get concurrent migration file name: %w", err)
+				}
+				if concurrentFile == regenerateFile {
+					regenerateFile = localFiles[len(localFiles)-2]
+				}
+			}
+
+			regenerateVersion, err := internal.GetMigrationVersion(regenerateFile)
+			if err != nil {
+				return err //nolint:wrapcheck
+			}
+
+			replayedFiles := slices.DeleteFunc(slices.Clone(localFiles), func(file string) bool {
+				version, _ := internal.GetMigrationVersion(file)
+
+				return version >= regenerateVersion
+			})
+			if len(replayedFiles) > 0 {
+				log.Printf(
+					"WARNING: only %s is regenerated, the earlier local migrations %s are replayed as they are. "+
+						"Check that they don't conflict with the merged migrations.\n",
+					regenerateFile,
+					strings.Join(replayedFiles, ", "),
+				)
+			}
+
+			regenerateFilePath := filepath.Join(migrationsDir, regenerateFile)
+			restore, err := backupGeneratedMigrationFiles(scheme, regenerateFilePath)
+			if err != nil {
+				return err
+			}
+
+			log.Printf("Regenerating %s\n", regenerateFile)
+
+			_, err = runWithFile(
+				ctx,
+				config,
+				wd,
+				tmpDir,
+				migrationsDir,
+				regenerateFilePath,
+				regenerateVersion,
+				false,
+				hazards,
+				postgresInstance,
+			)
+			if err != nil {
+				restoreErr := restore()
+				if restoreErr != nil {
+					log.Printf("WARNING: failed to restore %s: %v\n", regenerateFile, restoreErr)
+				} else {
+					log.Printf("Restored %s as it was before regenerating it\n", regenerateFile)
+				}
+
+				return fmt.Errorf("failed to regenerate migration: %w", err)
+			}
+
+			log.Println("Run trek check to verify the rebased migrations")
+
+			return nil
+		},
+	}
+
+	rebaseCmd.Flags().StringVar(&onto, "onto", "origin/main", "Git ref that has the merged migrations")
+	rebaseCmd.Flags().StringSliceVar(&hazards, "acknowledge-hazards", nil, "Hazard types to accept even if they are denied in the config") //nolint:lll
+	devPostgresFlags.Register(rebaseCmd)
+
+	return rebaseCmd
+}
+
+// backupGeneratedMigrationFiles reads the files of the migration that are
+// deleted when it is regenerated. It returns a function that deletes whatever
+// was written in their place and writes them back.
+func backupGeneratedMigrationFiles(scheme internal.VersionScheme, upMigrationFilePath string) (func() error, error) {
+	filePaths, err := generatedMigrationFilePaths(scheme, upMigrationFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	backup := map[string][]byte{}
+	for _, p := range filePaths {
+		content, err := os.ReadFile(p)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to back up %s: %w", filepath.Base(p), err)
+		}
+		backup[p] = content
+	}
+
+	return func() error {
+		for _, p := range filePaths {
+			err := os.Remove(p)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to delete %s: %w", filepath.Base(p), err)
+			}
+		}
+		for p, content := range backup {
+			err := os.WriteFile(p, content, 0o644) //nolint:gosec
+			if err != nil {
+				return fmt.Errorf("failed to restore %s: %w", filepath.Base(p), err)
+			}
+		}
+
+		return nil
+	}, nil
+}
+
+// findLocalMigrations returns the up migrations that are not merged, sorted by
+// version, and the latest version of the merged migrations.
+func findLocalMigrations(migrationFiles, mergedFiles []string) ([]string, uint, error) {
+	var latestVersion uint
+	for _, file := range mergedFiles {
+		if !strings.HasSuffix(file, ".up.sql") {
+			continue
+		}
+
+		version, err := internal.GetMigrationVersion(file)
+		if err != nil {
+			return nil, 0, err //nolint:wrapcheck
+		}
+		latestVersion = max(latestVersion, version)
+	}
+
+	type localMigration struct {
+		file    string
+		version uint
+	}
+
+	var localMigrations []localMigration
+	for _, file := range migrationFiles {
+		if slices.Contains(mergedFiles, file) {
+			continue
+		}
+
+		version, err := internal.GetMigrationVersion(file)
+		if err != nil {
+			return nil, 0, err //nolint:wrapcheck
+		}
+		localMigrations = append(localMigrations, localMigration{file: file, version: version})
+	}
+
+	// Sort by version, the names sort differently once the versions have more digits
+	slices.SortStableFunc(localMigrations, func(a, b localMigration) int {
+		return cmp.Compare(a.version, b.version)
+	})
+
+	localFiles := make([]string, 0, len(localMigrations))
+	for _, migration := range localMigrations {
+		localFiles = append(localFiles, migration.file)
+	}
+
+	return localFiles, latestVersion, nil
+}
+
+// renumberLocalMigrations gives the local migrations the versions after the
+// latest merged version. Testdata that has been merged stays where it is, even
+// if it has the same version as a local migration. It returns the new names of
+// the local migrations.
+func renumberLocalMigrations(
+	scheme internal.VersionScheme,
+	wd,
+	migrationsDir string,
+	localFiles,
+	mergedTestdata []string,
+	latestVersion uint,
+) ([]string, error) {
+	renamedFiles := slices.Clone(localFiles)
+
+	newVersions := make([]uint, len(localFiles))
+	for index := range localFiles {
+		if index == 0 {
+			newVersions[index] = scheme.NewVersion(latestVersion)
+		} else {
+			newVersions[index] = scheme.NextVersion(newVersions[index-1])
+		}
+	}
+
+	// Go backwards, so that a migration never gets the version of a local migration that hasn't been moved yet
+	for index := len(localFiles) - 1; index >= 0; index-- {
+		file := localFiles[index]
+		newVersion := newVersions[index]
+
+		version, err := internal.GetMigrationVersion(file)
+		if err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+		if version == newVersion {
+			continue
+		}
+
+		testdataFiles, err := internal.FindTestdata(wd, version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find testdata: %w", err)
+		}
+		testdataFiles = slices.DeleteFunc(testdataFiles, func(testdataFile string) bool {
+			return slices.Contains(mergedTestdata, filepath.Base(testdataFile))
+		})
+
+		renamedFiles[index], err = internal.RenumberMigration(scheme, migrationsDir, file, testdataFiles, newVersion)
+		if err != nil {
+			return nil, fmt.Errorf("failed to renumber migration: %w", err)
+		}
+		log.Printf("Renamed %s to %s\n", file, renamedFiles[index])
+	}
+
+	return renamedFiles, nil
+}
+
+// restoreSum replaces the sum file with the one of the merged migrations and
+// hashes the local migrations, which start at the given version. The sum file
+// usually has a merge conflict at this point.
+func restoreSum(ctx context.Context, wd, migrationsDir, onto string, fromVersion uint) error {
+	content, exists, err := internal.GitShowFile(ctx, wd, onto, internal.SumFileName)
+	if err != nil {
+		return fmt.Errorf("failed to read %s of %s: %w", internal.SumFileName, onto, err)
+	}
+	if !exists {
+		return rehash(wd, migrationsDir)
+	}
+
+	err = os.WriteFile(filepath.Join(wd, internal.SumFileName), []byte(content), 0o644) //nolint:gosec
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", internal.SumFileName, err)
+	}
+
+	err = internal.UpdateSum(wd, migrationsDir, fromVersion)
+	if err != nil {
+		return fmt.Errorf("failed to update %s: %w", internal.SumFileName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/printeers/trek/internal"
+)
+
+func NewRehashCommand() *cobra.Command {
+	rehashCmd := &cobra.Command{
+		Use:   "rehash",
+		Short: "Rewrite " + internal.SumFileName + " after intentionally changing migrations or testdata",
+		PersistentPreRun: func(cmd *cobra.Command, _ []string) {
+			internal.InitializeFlags(cmd)
+		},
+		RunE: func(_ *cobra.Command, _ []string) error {
+			wd, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("failed to get working directory: %w", err)
+			}
+
+			migrationsDir, err := internal.GetMigrationsDir(wd)
+			if err != nil {
+				return fmt.Errorf("failed to get migrations directory: %w", err)
+			}
+
+			return rehash(wd, migrationsDir)
+		},
+	}
+
+	return rehashCmd
+}
+
+// rehash hashes all migration and testdata files into the sum file.
+func rehash(wd, migrationsDir string) error {
+	sum, err := internal.HashFiles(wd, migrationsDir)
+	if err != nil {
+		return fmt.Errorf("failed to hash files: %w", err)
+	}
+
+	err = internal.WriteSum(wd, sum)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+	log.Printf("Wrote %d hashes to %s\n", len(sum), internal.SumFileName)
+
+	return nil
+}
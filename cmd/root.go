@@ -17,8 +17,15 @@ func NewRootCommand() *cobra.Command {
 
 	rootCmd.AddCommand(NewApplyCommand())
 	rootCmd.AddCommand(NewCheckCommand())
+	rootCmd.AddCommand(NewDriftCommand())
 	rootCmd.AddCommand(NewGenerateCommand())
+	rootCmd.AddCommand(NewImportCommand())
 	rootCmd.AddCommand(NewInitCommand())
+	rootCmd.AddCommand(NewLintCommand())
+	rootCmd.AddCommand(NewRebaseCommand())
+	rootCmd.AddCommand(NewRehashCommand())
+	rootCmd.AddCommand(NewSquashCommand())
+	rootCmd.AddCommand(NewStatusCommand())
 
 	return rootCmd
 }
@@ -0,0 +1,409 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/cobra"
+
+	"github.com/printeers/trek/internal"
+	"github.com/printeers/trek/internal/configuration"
+	"github.com/printeers/trek/internal/postgres"
+	"github.com/printeers/trek/internal/psql"
+)
+
+const (
+	squashDatabase         = "squash"
+	squashTestdataDatabase = "squash_testdata"
+	squashMigrationName    = "baseline"
+)
+
+var (
+	regexpDumpSet           = regexp.MustCompile(`(?m)^SET (\w+) = `)
+	regexpDumpMetaCommand   = regexp.MustCompile(`(?m)^\\.*\n`)
+	regexpDumpSetSearchPath = regexp.MustCompile(`set_config\('search_path', '', false\)`)
+)
+
+func NewSquashCommand() *cobra.Command {
+	var (
+		through uint
+
+		devPostgresFlags internal.DevPostgresFlags
+	)
+
+	squashCmd := &cobra.Command{
+		Use:   "squash",
+		Short: "Squash the first migrations into a single baseline migration",
+		PersistentPreRun: func(cmd *cobra.Command, _ []string) {
+			internal.InitializeFlags(cmd)
+		},
+		RunE: func(_ *cobra.Command, _ []string) error {
+			ctx := context.Background()
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("failed to get working directory: %w", err)
+			}
+
+			config, err := configuration.ReadConfig(wd)
+			if err != nil {
+				return fmt.Errorf("failed to read config: %w", err)
+			}
+
+			migrationsDir, err := internal.GetMigrationsDir(wd)
+			if err != nil {
+				return fmt.Errorf("failed to get migrations directory: %w", err)
+			}
+
+			migrationFiles, err := internal.FindMigrations(migrationsDir, true)
+			if err != nil {
+				return fmt.Errorf("failed to find migrations: %w", err)
+			}
+
+			squashedFiles, err := internal.MigrationsUpTo(migrationFiles, through)
+			if err != nil {
+				return fmt.Errorf("invalid --through: %w", err)
+			}
+			if len(squashedFiles) < 2 {
+				//nolint:err113
+				return fmt.Errorf("--through must be the version of the second migration or a later one")
+			}
+
+			postgresInstance, err := setupPostgresInstance(config, &devPostgresFlags)
+			if err != nil {
+				return fmt.Errorf("failed to setup instance: %w", err)
+			}
+			defer postgresInstance.Stop() //nolint:errcheck
+
+			baseline, err := dumpSquashedMigrations(ctx, config, migrationsDir, squashedFiles, postgresInstance)
+			if err != nil {
+				return err
+			}
+
+			testdata, testdataFiles, err := mergeSquashedTestdata(wd, squashedFiles)
+			if err != nil {
+				return err
+			}
+			if testdata != "" {
+				err = checkSquashedTestdata(ctx, postgresInstance, baseline, testdata)
+				if err != nil {
+					return err
+				}
+			}
+
+			return squashMigrations(
+				config,
+				wd,
+				migrationsDir,
+				migrationFiles,
+				len(squashedFiles),
+				baseline,
+				testdata,
+				testdataFiles,
+			)
+		},
+	}
+
+	devPostgresFlags.Register(squashCmd)
+	squashCmd.Flags().UintVar(&through, "through", 0, "Version of the last migration to squash into the baseline")
+	internal.MarkFlagRequired(squashCmd, "through")
+
+	return squashCmd
+}
+
+// dumpSquashedMigrations replays the migrations and returns a dump of the
+// resulting database that can be run by golang-migrate as a single migration.
+func dumpSquashedMigrations(
+	ctx context.Context,
+	config *configuration.Config,
+	migrationsDir string,
+	migrationFiles []string,
+	postgresInstance postgres.Instance,
+) (string, error) {
+	for _, role := range config.Roles {
+		err := postgresInstance.CreateRole(ctx, role.Name)
+		if err != nil {
+			return "", fmt.Errorf("failed to create role %q: %w", role.Name, err)
+		}
+	}
+
+	err := postgresInstance.CreateDatabase(ctx, squashDatabase)
+	if err != nil {
+		return "", fmt.Errorf("failed to create %s database: %w", squashDatabase, err)
+	}
+
+	dsn := postgresInstance.DSN(squashDatabase)
+
+	log.Printf("Replaying migrations %s to %s\n", migrationFiles[0], migrationFiles[len(migrationFiles)-1])
+
+	m, err := internal.NewMigrator(migrationsDir, dsn, config.VersionOffset)
+	if err != nil {
+		return "", fmt.Errorf("failed to initialize migrator: %w", err)
+	}
+	defer m.Close() //nolint:errcheck
+
+	err = m.Up(migrationFiles)
+	if err != nil {
+		return "", fmt.Errorf("failed to replay migrations: %w", err)
+	}
+
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to %s database: %w", squashDatabase, err)
+	}
+	defer conn.Close(ctx)
+
+	var superuser string
+	err = conn.QueryRow(ctx, "SELECT current_user;").Scan(&superuser)
+	if err != nil {
+		return "", fmt.Errorf("failed to get current user: %w", err)
+	}
+
+	// Data that the migrations insert is part of the baseline. COPY doesn't work in golang-migrate, so use inserts.
+	dump, err := postgres.PgDump(ctx, dsn, []string{
+		"--column-inserts",
+		"--exclude-table=public.schema_migrations",
+	})
+	if err != nil {
+		//nolint:wrapcheck
+		return "", err
+	}
+
+	// golang-migrate runs all migrations on the same session, the settings of the dump must not leak into the next
+	// migrations. Objects owned by the superuser of the instance are owned by whoever runs the migrations instead.
+	dump = regexpDumpMetaCommand.ReplaceAllString(dump, "")
+	dump = regexpDumpSet.ReplaceAllString(dump, "SET LOCAL $1 = ")
+	dump = regexpDumpSetSearchPath.ReplaceAllString(dump, "set_config('search_path', '', true)")
+	regexpOwner := regexp.MustCompile(
+		fmt.Sprintf(`(?m)^ALTER .* OWNER TO (%s|%q);\n`, regexp.QuoteMeta(superuser), superuser),
+	)
+	dump = regexpOwner.ReplaceAllString(dump, "")
+
+	return fmt.Sprintf(
+		"-- Baseline of the migrations %s to %s, created with trek squash\n\n%s",
+		migrationFiles[0],
+		migrationFiles[len(migrationFiles)-1],
+		dump,
+	), nil
+}
+
+// mergeSquashedTestdata returns the testdata of the squashed migrations
+// merged into a single file, and the files it was merged from.
+func mergeSquashedTestdata(wd string, squashedFiles []string) (string, []string, error) {
+	var testdata strings.Builder
+	var mergedFiles []string
+	for _, file := range squashedFiles {
+		version, err := internal.GetMigrationVersion(file)
+		if err != nil {
+			return "", nil, err //nolint:wrapcheck
+		}
+
+		var testdataFiles []string
+		testdataFiles, err = internal.FindTestdata(wd, version)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to find testdata: %w", err)
+		}
+
+		for _, testdataFile := range testdataFiles {
+			var content []byte
+			content, err = os.ReadFile(testdataFile)
+			if err != nil {
+				return "", nil, fmt.Errorf("failed to read testdata: %w", err)
+			}
+
+			testdata.WriteString(fmt.Sprintf(
+				"-- %s\n%s\n",
+				filepath.Base(testdataFile),
+				strings.TrimRight(string(content), "\n"),
+			))
+			mergedFiles = append(mergedFiles, testdataFile)
+		}
+	}
+
+	return testdata.String(), mergedFiles, nil
+}
+
+// checkSquashedTestdata runs the merged testdata after the baseline in a new
+// database. The testdata of each squashed migration used to run against the
+// schema of its own migration, the merged testdata runs against the schema of
+// the last squashed migration. Testdata that refers to something a later
+// squashed migration changed has to be fixed before squashing.
+func checkSquashedTestdata(ctx context.Context, postgresInstance postgres.Instance, baseline, testdata string) error {
+	err := postgresInstance.CreateDatabase(ctx, squashTestdataDatabase)
+	if err != nil {
+		return fmt.Errorf("failed to create %s database: %w", squashTestdataDatabase, err)
+	}
+
+	dsn := postgresInstance.DSN(squashTestdataDatabase)
+
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s database: %w", squashTestdataDatabase, err)
+	}
+	defer conn.Close(ctx)
+
+	_, err = conn.Exec(ctx, baseline)
+	if err != nil {
+		return fmt.Errorf("failed to apply baseline: %w", err)
+	}
+
+	testdataFile, err := os.CreateTemp("", "trek-squash-*.sql")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary testdata file: %w", err)
+	}
+	defer os.Remove(testdataFile.Name()) //nolint:errcheck
+
+	_, err = testdataFile.WriteString(testdata)
+	if err != nil {
+		return fmt.Errorf("failed to write temporary testdata file: %w", err)
+	}
+	err = testdataFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close temporary testdata file: %w", err)
+	}
+
+	log.Println("Checking the merged testdata against the baseline")
+
+	err = psql.RunFile(ctx, dsn, testdataFile.Name(), nil)
+	if err != nil {
+		return fmt.Errorf(
+			"merged testdata fails against the schema of the last squashed migration, fix the testdata first: %w",
+			err,
+		)
+	}
+
+	return nil
+}
+
+// squashMigrations replaces the first migrations with the baseline and their
+// testdata with the merged testdata. The new files are written before the old
+// ones are deleted. With sequential versions the remaining migrations are
+// renumbered and the version offset in the config is raised, so that
+// databases keep their version. With timestamp versions the baseline gets the
+// version of the last squashed migration instead.
+//
+//nolint:cyclop
+func squashMigrations(
+	config *configuration.Config,
+	wd,
+	migrationsDir string,
+	migrationFiles []string,
+	squashed int,
+	baseline,
+	testdata string,
+	testdataFiles []string,
+) error {
+	scheme := internal.NewVersionScheme(config)
+
+	baselineVersion := scheme.FirstVersion()
+	if scheme.Timestamp() {
+		var err error
+		baselineVersion, err = internal.GetMigrationVersion(migrationFiles[squashed-1])
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+	}
+
+	baselineFile := filepath.Join(migrationsDir, scheme.MigrationFileName(baselineVersion, squashMigrationName))
+	err := os.WriteFile(baselineFile, []byte(baseline), 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write baseline migration: %w", err)
+	}
+	log.Printf("Wrote %s\n", filepath.Base(baselineFile))
+
+	var mergedTestdataFile string
+	if testdata != "" {
+		mergedTestdataFile = filepath.Join(wd, "testdata", scheme.TestdataFileName(baselineVersion, squashMigrationName))
+		err = os.WriteFile(mergedTestdataFile, []byte(testdata), 0o600)
+		if err != nil {
+			return fmt.Errorf("failed to write testdata: %w", err)
+		}
+		log.Printf("Merged the testdata into %s\n", filepath.Base(mergedTestdataFile))
+	}
+
+	for _, testdataFile := range testdataFiles {
+		if testdataFile == mergedTestdataFile {
+			continue
+		}
+		err = os.Remove(testdataFile)
+		if err != nil {
+			return fmt.Errorf("failed to delete testdata: %w", err)
+		}
+	}
+
+	for _, file := range migrationFiles[:squashed] {
+		for _, path := range []string{file, internal.GetDownMigrationFileName(file)} {
+			path = filepath.Join(migrationsDir, path)
+			if path == baselineFile {
+				continue
+			}
+			err = os.Remove(path)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to delete migration: %w", err)
+			}
+		}
+	}
+
+	if !scheme.Timestamp() {
+		err = renumberSquashedMigrations(scheme, config, wd, migrationsDir, migrationFiles[squashed:], squashed)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = rehash(wd, migrationsDir)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Run trek check to verify the squashed migrations")
+
+	return nil
+}
+
+// renumberSquashedMigrations renumbers the migrations after the baseline and
+// raises the version offset by the number of migrations that are gone.
+func renumberSquashedMigrations(
+	scheme internal.VersionScheme,
+	config *configuration.Config,
+	wd,
+	migrationsDir string,
+	migrationFiles []string,
+	squashed int,
+) error {
+	for index, file := range migrationFiles {
+		version, err := internal.GetMigrationVersion(file)
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		var testdataFiles []string
+		testdataFiles, err = internal.FindTestdata(wd, version)
+		if err != nil {
+			return fmt.Errorf("failed to find testdata: %w", err)
+		}
+
+		var newFile string
+		newFile, err = internal.RenumberMigration(scheme, migrationsDir, file, testdataFiles, uint(index)+2) //nolint:gosec
+		if err != nil {
+			return fmt.Errorf("failed to renumber migration: %w", err)
+		}
+		log.Printf("Renamed %s to %s\n", file, newFile)
+	}
+
+	versionOffset := config.VersionOffset + uint(squashed) - 1 //nolint:gosec
+	err := configuration.WriteVersionOffset(wd, versionOffset)
+	if err != nil {
+		return fmt.Errorf("failed to update config: %w", err)
+	}
+	log.Printf("Set version_offset to %d in %s\n", versionOffset, configuration.FileName)
+
+	return nil
+}
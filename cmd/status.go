@@ -0,0 +1,235 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/spf13/cobra"
+
+	"github.com/printeers/trek/internal"
+	"github.com/printeers/trek/internal/configuration"
+	internalpostgres "github.com/printeers/trek/internal/postgres"
+)
+
+var errInvalidFormat = errors.New("invalid format")
+
+const (
+	formatTable = "table"
+	formatSQL   = "sql"
+	formatJSON  = "json"
+)
+
+//nolint:tagliatelle
+type databaseStatus struct {
+	Database       string `json:"database"`
+	DatabaseExists bool   `json:"database_exists"`
+	Version        *uint  `json:"version"`
+	// SquashedVersion is the version of a database that is at a version from before a squash. Its migrations have
+	// been squashed into the baseline, Version is nil then.
+	SquashedVersion *uint                   `json:"squashed_version"`
+	Dirty           bool                    `json:"dirty"`
+	LatestVersion   uint                    `json:"latest_version"`
+	UnknownVersion  bool                    `json:"unknown_version"`
+	Migrations      []migrationStatusRecord `json:"migrations"`
+}
+
+const (
+	migrationStatusApplied = "applied"
+	migrationStatusPending = "pending"
+	// migrationStatusDirty is the status of the migration at the dirty version of the database, which failed.
+	migrationStatusDirty = "dirty"
+	// migrationStatusPartial is the status of the baseline of a squash if the database is at a squashed version.
+	migrationStatusPartial = "partial"
+)
+
+type migrationStatusRecord struct {
+	File    string `json:"file"`
+	Version uint   `json:"version"`
+	Status  string `json:"status"`
+}
+
+//nolint:gocognit,cyclop
+func NewStatusCommand() *cobra.Command {
+	var (
+		postgresFlags internal.PostgresFlags
+		format        string
+	)
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show which migrations have been applied to a running database",
+		PersistentPreRun: func(cmd *cobra.Command, _ []string) {
+			internal.InitializeFlags(cmd)
+		},
+		Args: func(_ *cobra.Command, _ []string) error {
+			if format != formatTable && format != formatJSON {
+				return fmt.Errorf("%w %q, use %q or %q", errInvalidFormat, format, formatTable, formatJSON)
+			}
+
+			return nil
+		},
+		RunE: func(_ *cobra.Command, _ []string) error {
+			ctx := context.Background()
+
+			wd, err := os.Getwd()
+			if err != nil {
+				return fmt.Errorf("failed to get working directory: %w", err)
+			}
+
+			config, err := configuration.ReadConfig(wd)
+			if err != nil {
+				return fmt.Errorf("failed to read config: %w", err)
+			}
+
+			migrationsDir, err := internal.GetMigrationsDir(wd)
+			if err != nil {
+				return fmt.Errorf("failed to get migrations directory: %w", err)
+			}
+
+			migrationFiles, err := internal.FindMigrations(migrationsDir, true)
+			if err != nil {
+				return fmt.Errorf("failed to find migrations: %w", err)
+			}
+
+			status, err := getDatabaseStatus(ctx, config, &postgresFlags, migrationFiles)
+			if err != nil {
+				return err
+			}
+
+			if format == formatJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+
+				//nolint:wrapcheck
+				return encoder.Encode(status)
+			}
+
+			return printDatabaseStatus(status)
+		},
+	}
+
+	postgresFlags.Register(statusCmd)
+	statusCmd.Flags().StringVar(&format, "format", formatTable, "Output format, either table or json")
+
+	return statusCmd
+}
+
+func getDatabaseStatus(
+	ctx context.Context,
+	config *configuration.Config,
+	postgresFlags *internal.PostgresFlags,
+	migrationFiles []string,
+) (*databaseStatus, error) {
+	status := &databaseStatus{
+		Database:   config.DatabaseName,
+		Migrations: []migrationStatusRecord{},
+	}
+
+	conn, err := pgx.Connect(ctx, postgresFlags.DSN("postgres"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	status.DatabaseExists, err = internalpostgres.CheckDatabaseExists(ctx, conn, config.DatabaseName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if database exists: %w", err)
+	}
+
+	var version uint
+	var found bool
+	if status.DatabaseExists {
+		var databaseConn *pgx.Conn
+		databaseConn, err = pgx.Connect(ctx, postgresFlags.DSN(config.DatabaseName))
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to database: %w", err)
+		}
+		defer databaseConn.Close(ctx)
+
+		version, status.Dirty, found, err = internalpostgres.GetSchemaMigrationsVersion(ctx, databaseConn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get database version: %w", err)
+		}
+
+		databaseVersion := version
+		version, err = internal.FileVersion(version, config.VersionOffset)
+		if errors.Is(err, internal.ErrSquashedVersion) {
+			status.SquashedVersion = &databaseVersion
+			found = false
+		} else if err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+		if found {
+			status.Version = &version
+		}
+	}
+
+	for index, file := range migrationFiles {
+		var fileVersion uint
+		fileVersion, err = internal.GetMigrationVersion(file)
+		if err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+		status.LatestVersion = max(status.LatestVersion, fileVersion)
+
+		state := migrationStatusPending
+		switch {
+		case index == 0 && status.SquashedVersion != nil:
+			state = migrationStatusPartial
+		case found && status.Dirty && fileVersion == version:
+			state = migrationStatusDirty
+		case found && fileVersion <= version:
+			state = migrationStatusApplied
+		}
+
+		status.Migrations = append(status.Migrations, migrationStatusRecord{
+			File:    file,
+			Version: fileVersion,
+			Status:  state,
+		})
+	}
+
+	status.UnknownVersion = found && version > status.LatestVersion
+
+	return status, nil
+}
+
+func printDatabaseStatus(status *databaseStatus) error {
+	switch {
+	case !status.DatabaseExists:
+		fmt.Printf("Database %q does not exist\n", status.Database)
+	case status.SquashedVersion != nil:
+		fmt.Printf("Database %q is at version %d from before the squash\n", status.Database, *status.SquashedVersion)
+		fmt.Println("WARNING: the version has been squashed into the baseline, " +
+			"apply the rest of the baseline with the migrations from before the squash")
+	case status.Version == nil:
+		fmt.Printf("Database %q has no migrations applied\n", status.Database)
+	default:
+		fmt.Printf("Database %q is at version %d\n", status.Database, *status.Version)
+	}
+	if status.Dirty {
+		fmt.Println("WARNING: the database is dirty, the last migration failed and must be fixed manually")
+	}
+	if status.UnknownVersion {
+		fmt.Printf(
+			"WARNING: version %d is newer than the latest migration (version %d)\n",
+			*status.Version,
+			status.LatestVersion,
+		)
+	}
+	fmt.Println("")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(w, "VERSION\tMIGRATION\tSTATUS")
+	for _, migration := range status.Migrations {
+		_, _ = fmt.Fprintf(w, "%d\t%s\t%s\n", migration.Version, migration.File, migration.Status)
+	}
+
+	//nolint:wrapcheck
+	return w.Flush()
+}
@@ -6,14 +6,23 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/stripe/pg-schema-diff/pkg/diff"
 	"gopkg.in/yaml.v2"
 )
 
 const regexpStringValidIdentifier = `^[a-z_]+$`
 
+// maxVersionDigits is the number of digits of the largest version golang-migrate can store.
+const maxVersionDigits = 19
+
 var regexpValidIdentifier = regexp.MustCompile(regexpStringValidIdentifier)
 
+var regexpVersionOffset = regexp.MustCompile(`(?m)^version_offset:.*$`)
+
 var ErrInvalidValuesInConfig = errors.New("invalid values in config")
 
 type Config struct {
@@ -25,6 +34,152 @@ type Config struct {
 	Roles     []Role     `yaml:"roles"`
 	Templates []Template `yaml:"templates"`
 	Output    *Output    `yaml:"output"`
+	// Hazards maps pg-schema-diff hazard types to the policy generate applies to them.
+	Hazards map[string]HazardPolicy `yaml:"hazards"`
+	// Timeouts override the statement and lock timeouts pg-schema-diff sets for generated statements.
+	Timeouts *Timeouts `yaml:"timeouts"`
+	// ConcurrentIndexOps allows CREATE/DROP INDEX CONCURRENTLY. These statements are written to a separate migration
+	// that runs outside a transaction.
+	//nolint:tagliatelle
+	ConcurrentIndexOps bool `yaml:"concurrent_index_ops"`
+	// Lint maps lint rule IDs to the severity they are reported with.
+	Lint map[string]LintSeverity `yaml:"lint"`
+	// VersionOffset is added to the version of a migration file to get the version golang-migrate stores in the
+	// database. trek squash raises it, so that databases keep their version when migrations are renumbered.
+	//nolint:tagliatelle
+	VersionOffset uint `yaml:"version_offset"`
+	// Versions configures the versions in the names of migration and testdata files.
+	Versions *Versions `yaml:"versions"`
+	// Hooks configures the timeouts and error policies of the hooks.
+	Hooks *Hooks `yaml:"hooks"`
+	// DevPostgres configures the PostgreSQL instance used to generate and check migrations.
+	//nolint:tagliatelle
+	DevPostgres *DevPostgres `yaml:"dev_postgres"`
+}
+
+type DevPostgres struct {
+	// Port of the embedded instance. A free port is picked if it is not set.
+	Port uint32 `yaml:"port"`
+	// DSN of an existing server to use instead of the embedded instance.
+	DSN string `yaml:"dsn"`
+	// DisableSnapshots stops trek from caching the database after the migrations have been replayed.
+	//nolint:tagliatelle
+	DisableSnapshots bool `yaml:"disable_snapshots"`
+}
+
+type Versions struct {
+	Scheme VersionScheme `yaml:"scheme"`
+	// Digits is the width sequential versions are zero padded to, 3 by default.
+	Digits uint `yaml:"digits"`
+}
+
+type VersionScheme string
+
+const (
+	// VersionSchemeSequential numbers the migrations 1, 2, 3 and so on. This is the default.
+	VersionSchemeSequential VersionScheme = "sequential"
+	// VersionSchemeTimestamp uses the UTC time a migration was created as its version, formatted as YYYYMMDDHHMMSS.
+	VersionSchemeTimestamp VersionScheme = "timestamp"
+)
+
+// HookSettings configure how a hook is run. Zero values keep the defaults.
+type HookSettings struct {
+	// Timeout is a duration such as "30s" after which the hook is killed. Hooks have no timeout by default.
+	Timeout time.Duration `yaml:"timeout"`
+	//nolint:tagliatelle
+	OnError HookErrorPolicy `yaml:"on_error"`
+}
+
+// Hooks holds the settings of all hooks and the overrides of single hooks.
+type Hooks struct {
+	HookSettings `yaml:",inline"`
+	// Overrides are keyed by hook name, e.g. migration-pre.
+	Overrides map[string]HookSettings `yaml:"overrides"`
+}
+
+type HookErrorPolicy string
+
+const (
+	// HookErrorPolicyFail stops trek when the hook fails or times out. This is the default.
+	HookErrorPolicyFail HookErrorPolicy = "fail"
+	// HookErrorPolicyIgnore logs a warning and continues.
+	HookErrorPolicyIgnore HookErrorPolicy = "ignore"
+)
+
+// HookNames are the names of the hooks trek runs, in the order of the commands that run them.
+//
+//nolint:gochecknoglobals
+var HookNames = []string{
+	"generate-pre",
+	"generate-migration-post",
+	"check-pre",
+	"check-post",
+	"apply-pre",
+	"apply-reset-pre",
+	"migration-pre",
+	"migration-post",
+	"apply-reset-post",
+	"apply-post",
+}
+
+// Timeout holds durations such as "30s" or "5m". A zero value keeps the timeout that was set before.
+type Timeout struct {
+	//nolint:tagliatelle
+	StatementTimeout time.Duration `yaml:"statement_timeout"`
+	//nolint:tagliatelle
+	LockTimeout time.Duration `yaml:"lock_timeout"`
+}
+
+// Timeouts are applied in order: the defaults, then the overrides per hazard
+// type and finally the overrides per table, so the most specific one wins.
+// The defaults don't replace the longer timeouts pg-schema-diff picks itself.
+// If several overrides of the same kind match, the longest timeout is used.
+type Timeouts struct {
+	Timeout `yaml:",inline"`
+	// Tables are keyed by "schema.table", a key without schema refers to the public schema.
+	Tables map[string]Timeout `yaml:"tables"`
+	// Hazards are keyed by pg-schema-diff hazard type, e.g. INDEX_BUILD.
+	Hazards map[string]Timeout `yaml:"hazards"`
+}
+
+type HazardPolicy string
+
+const (
+	// HazardPolicyAllow only annotates the hazard in the migration file. This is the default.
+	HazardPolicyAllow HazardPolicy = "allow"
+	// HazardPolicyWarn annotates the hazard and logs a warning.
+	HazardPolicyWarn HazardPolicy = "warn"
+	// HazardPolicyDeny makes generate fail unless the hazard is acknowledged.
+	HazardPolicyDeny HazardPolicy = "deny"
+)
+
+type LintSeverity string
+
+const (
+	// LintSeverityOff disables the rule.
+	LintSeverityOff LintSeverity = "off"
+	// LintSeverityWarning reports findings without failing.
+	LintSeverityWarning LintSeverity = "warning"
+	// LintSeverityError reports findings and fails lint and check.
+	LintSeverityError LintSeverity = "error"
+)
+
+// LintRuleIDs are the IDs of the built-in lint rules, the keys lint accepts.
+//
+//nolint:gochecknoglobals
+var LintRuleIDs = []string{
+	"alter-column-type-without-using",
+	"drop-column",
+	"foreign-key-without-index",
+	"missing-comment",
+	"missing-primary-key",
+	"non-concurrent-index",
+	"nullable-without-default",
+	"primary-key-name",
+	"sequence-name",
+	"set-not-null",
+	"snake-case-name",
+	"vacuum-full",
 }
 
 type Role struct {
@@ -46,9 +201,12 @@ type Output struct {
 	SVG *OutputFile `yaml:"svg"`
 }
 
+// FileName is the name of the config file in the working directory.
+const FileName = "trek.yaml"
+
 func ReadConfig(wd string) (*Config, error) {
 	var config *Config
-	file, err := os.ReadFile(filepath.Join(wd, "trek.yaml"))
+	file, err := os.ReadFile(filepath.Join(wd, FileName))
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %w", err)
 	}
@@ -69,6 +227,35 @@ func ReadConfig(wd string) (*Config, error) {
 	return config, nil
 }
 
+// WriteVersionOffset sets version_offset in the config file. The rest of the
+// file, including comments, is kept as it is.
+func WriteVersionOffset(wd string, offset uint) error {
+	path := filepath.Join(wd, FileName)
+
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	line := fmt.Sprintf("version_offset: %d", offset)
+	content := string(file)
+	if regexpVersionOffset.MatchString(content) {
+		content = regexpVersionOffset.ReplaceAllLiteralString(content, line)
+	} else {
+		if content != "" && !strings.HasSuffix(content, "\n") {
+			content += "\n"
+		}
+		content += line + "\n"
+	}
+
+	err = os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+
+	return nil
+}
+
 func (c *Config) validate() (problems []string) {
 	if !ValidateIdentifier(c.ModelName) {
 		p := fmt.Sprintf("Model name %q contains invalid characters. Must match %q.",
@@ -94,9 +281,196 @@ func (c *Config) validate() (problems []string) {
 		}
 	}
 
+	for hazardType, policy := range c.Hazards {
+		if !slices.Contains(hazardTypes(), hazardType) {
+			problems = append(problems, fmt.Sprintf("Hazard %q is unknown. Must be one of %q.", hazardType, hazardTypes()))
+		}
+		switch policy {
+		case HazardPolicyAllow, HazardPolicyWarn, HazardPolicyDeny:
+		default:
+			p := fmt.Sprintf("Hazard policy %q of hazard %q is invalid. Must be one of %q, %q or %q.",
+				policy,
+				hazardType,
+				HazardPolicyAllow,
+				HazardPolicyWarn,
+				HazardPolicyDeny,
+			)
+			problems = append(problems, p)
+		}
+	}
+
+	for rule, severity := range c.Lint {
+		if !slices.Contains(LintRuleIDs, rule) {
+			problems = append(problems, fmt.Sprintf("Lint rule %q is unknown. Must be one of %q.", rule, LintRuleIDs))
+		}
+		switch severity {
+		case LintSeverityOff, LintSeverityWarning, LintSeverityError:
+		default:
+			p := fmt.Sprintf("Lint severity %q of rule %q is invalid. Must be one of %q, %q or %q.",
+				severity,
+				rule,
+				LintSeverityOff,
+				LintSeverityWarning,
+				LintSeverityError,
+			)
+			problems = append(problems, p)
+		}
+	}
+
+	if c.Versions != nil {
+		switch c.Versions.Scheme {
+		case "", VersionSchemeSequential, VersionSchemeTimestamp:
+		default:
+			p := fmt.Sprintf("Version scheme %q is invalid. Must be one of %q or %q.",
+				c.Versions.Scheme,
+				VersionSchemeSequential,
+				VersionSchemeTimestamp,
+			)
+			problems = append(problems, p)
+		}
+		if c.Versions.Digits > maxVersionDigits {
+			problems = append(problems, fmt.Sprintf("Version digits must not be more than %d.", maxVersionDigits))
+		}
+	}
+
+	if c.Hooks != nil {
+		problems = append(problems, c.Hooks.validate()...)
+	}
+
+	if c.Timeouts != nil {
+		timeouts := map[string]Timeout{"default": c.Timeouts.Timeout}
+		for table, timeout := range c.Timeouts.Tables {
+			timeouts["table "+table] = timeout
+		}
+		for hazardType, timeout := range c.Timeouts.Hazards {
+			if !slices.Contains(hazardTypes(), hazardType) {
+				problems = append(problems, fmt.Sprintf(
+					"Timeouts of hazard %q are invalid, the hazard is unknown. Must be one of %q.",
+					hazardType,
+					hazardTypes(),
+				))
+			}
+			timeouts["hazard "+hazardType] = timeout
+		}
+		for name, timeout := range timeouts {
+			if timeout.StatementTimeout < 0 || timeout.LockTimeout < 0 {
+				problems = append(problems, fmt.Sprintf("Timeouts of %s must not be negative.", name))
+			}
+		}
+	}
+
 	return problems
 }
 
+// hazardTypes returns the hazard types pg-schema-diff reports.
+func hazardTypes() []string {
+	return []string{
+		diff.MigrationHazardTypeAcquiresAccessExclusiveLock,
+		diff.MigrationHazardTypeAcquiresShareLock,
+		diff.MigrationHazardTypeAcquiresShareRowExclusiveLock,
+		diff.MigrationHazardTypeCorrectness,
+		diff.MigrationHazardTypeDeletesData,
+		diff.MigrationHazardTypeHasUntrackableDependencies,
+		diff.MigrationHazardTypeIndexBuild,
+		diff.MigrationHazardTypeIndexDropped,
+		diff.MigrationHazardTypeImpactsDatabasePerformance,
+		diff.MigrationHazardTypeIsUserGenerated,
+		diff.MigrationHazardTypeExtensionVersionUpgrade,
+		diff.MigrationHazardTypeAuthzUpdate,
+	}
+}
+
+func (h *Hooks) validate() (problems []string) {
+	settings := map[string]HookSettings{"all hooks": h.HookSettings}
+	for hookName, s := range h.Overrides {
+		if !slices.Contains(HookNames, hookName) {
+			problems = append(problems, fmt.Sprintf("Hook %q is unknown. Must be one of %q.", hookName, HookNames))
+		}
+		settings["hook "+hookName] = s
+	}
+
+	for name, s := range settings {
+		switch s.OnError {
+		case "", HookErrorPolicyFail, HookErrorPolicyIgnore:
+		default:
+			p := fmt.Sprintf("Hook error policy %q of %s is invalid. Must be one of %q or %q.",
+				s.OnError,
+				name,
+				HookErrorPolicyFail,
+				HookErrorPolicyIgnore,
+			)
+			problems = append(problems, p)
+		}
+		if s.Timeout < 0 {
+			problems = append(problems, fmt.Sprintf("Hook timeout of %s must not be negative.", name))
+		}
+	}
+
+	return problems
+}
+
+// GetHazardPolicy returns the configured policy for the given hazard type, defaulting to HazardPolicyAllow.
+func (c *Config) GetHazardPolicy(hazardType string) HazardPolicy {
+	if policy, ok := c.Hazards[hazardType]; ok {
+		return policy
+	}
+
+	return HazardPolicyAllow
+}
+
+// GetHookSettings returns the settings of the given hook. Overrides of the
+// hook take precedence over the settings of all hooks.
+func (c *Config) GetHookSettings(hookName string) HookSettings {
+	settings := HookSettings{OnError: HookErrorPolicyFail}
+	if c.Hooks == nil {
+		return settings
+	}
+
+	for _, s := range []HookSettings{c.Hooks.HookSettings, c.Hooks.Overrides[hookName]} {
+		if s.Timeout > 0 {
+			settings.Timeout = s.Timeout
+		}
+		if s.OnError != "" {
+			settings.OnError = s.OnError
+		}
+	}
+
+	return settings
+}
+
+// GetDevPostgresPort returns the configured port of the embedded instance, or 0 if a free port should be picked.
+func (c *Config) GetDevPostgresPort() uint32 {
+	if c.DevPostgres == nil {
+		return 0
+	}
+
+	return c.DevPostgres.Port
+}
+
+// GetDevPostgresDSN returns the DSN of the existing server to use, or an empty string to use the embedded instance.
+func (c *Config) GetDevPostgresDSN() string {
+	if c.DevPostgres == nil {
+		return ""
+	}
+
+	return c.DevPostgres.DSN
+}
+
+// SnapshotsEnabled reports whether replayed migrations may be cached.
+func (c *Config) SnapshotsEnabled() bool {
+	return c.DevPostgres == nil || !c.DevPostgres.DisableSnapshots
+}
+
+// RoleNames returns the names of the configured roles.
+func (c *Config) RoleNames() []string {
+	names := make([]string, 0, len(c.Roles))
+	for _, role := range c.Roles {
+		names = append(names, role.Name)
+	}
+
+	return names
+}
+
 // GetOutputPath returns the output path for the given type if enabled, or empty string if not.
 // The outputType must be one of: "sql", "png", "svg". Panics if an invalid outputType is provided.
 func (c *Config) GetOutputPath(outputType string) string {
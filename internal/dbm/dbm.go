@@ -3,18 +3,458 @@ package dbm
 
 import "encoding/xml"
 
+// DBModel is a pgModeler model. Use Parse or ReadFile to get a model with
+// resolved cross-references.
 type DBModel struct {
-	XMLName   xml.Name   `xml:"dbmodel"`
-	Roles     []Role     `xml:"role"`
-	Databases []Database `xml:"database"`
+	XMLName       xml.Name       `xml:"dbmodel"`
+	LayerNames    string         `xml:"layers,attr"`
+	Roles         []Role         `xml:"role"`
+	Databases     []Database     `xml:"database"`
+	Tags          []*Tag         `xml:"tag"`
+	Schemas       []*Schema      `xml:"schema"`
+	Extensions    []*Extension   `xml:"extension"`
+	Types         []*Type        `xml:"usertype"`
+	Domains       []*Domain      `xml:"domain"`
+	Sequences     []*Sequence    `xml:"sequence"`
+	Tables        []*Table       `xml:"table"`
+	Views         []*View        `xml:"view"`
+	Functions     []*Function    `xml:"function"`
+	Triggers      []*Trigger     `xml:"trigger"`
+	Indexes       []*Index       `xml:"index"`
+	Constraints   []*Constraint  `xml:"constraint"`
+	Relationships []Relationship `xml:"relationship"`
+	Permissions   []*Permission  `xml:"permission"`
+
+	// Layers are the names of the layers, objects refer to them by index.
+	Layers []string `xml:"-"`
 }
 
 type Role struct {
 	Name        string `xml:"name,attr"`
 	SQLDisabled bool   `xml:"sql-disabled,attr"`
+	Superuser   bool   `xml:"superuser,attr"`
+	CreateDB    bool   `xml:"createdb,attr"`
+	CreateRole  bool   `xml:"createrole,attr"`
+	Inherit     bool   `xml:"inherit,attr"`
+	Login       bool   `xml:"login,attr"`
+	Comment     string `xml:"comment"`
 }
 
 type Database struct {
 	Name        string `xml:"name,attr"`
 	SQLDisabled bool   `xml:"sql-disabled,attr"`
+	Encoding    string `xml:"encoding,attr"`
+	IsTemplate  bool   `xml:"is-template,attr"`
+	AllowConns  bool   `xml:"allow-conns,attr"`
+	Comment     string `xml:"comment"`
+}
+
+// Reference is an element that refers to another object by its name, such as <schema name="public"/>.
+type Reference struct {
+	Name string `xml:"name,attr"`
+}
+
+// object holds the elements and attributes shared by most objects of a model.
+type object struct {
+	Name        string     `xml:"name,attr"`
+	SQLDisabled bool       `xml:"sql-disabled,attr"`
+	LayerIDs    string     `xml:"layers,attr"`
+	OwnerRef    *Reference `xml:"role"`
+	TagRef      *Reference `xml:"tag"`
+	RawComment  string     `xml:"comment"`
+	Position    *Position  `xml:"position"`
+	Layers      []string   `xml:"-"`
+	Owner       *Role      `xml:"-"`
+	Tag         *Tag       `xml:"-"`
+}
+
+// Comment returns the comment of the object without the whitespace pgModeler puts around it.
+func (o *object) Comment() string {
+	return trimCDATA(o.RawComment)
+}
+
+// OwnerName returns the name of the owner, which may be a role that is not part of the model such as postgres.
+func (o *object) OwnerName() string {
+	if o.OwnerRef == nil {
+		return ""
+	}
+
+	return o.OwnerRef.Name
+}
+
+// schemaObject is an object that lives in a schema.
+type schemaObject struct {
+	object
+
+	SchemaRef Reference `xml:"schema"`
+	Schema    *Schema   `xml:"-"`
+}
+
+// QualifiedName returns the name of the object including its schema, e.g. public.users.
+func (o *schemaObject) QualifiedName() string {
+	return qualifiedName(o.SchemaRef.Name, o.Name)
+}
+
+type Position struct {
+	X float64 `xml:"x,attr"`
+	Y float64 `xml:"y,attr"`
+}
+
+// Tag groups objects visually. Objects refer to tags by name.
+type Tag struct {
+	Name    string `xml:"name,attr"`
+	Comment string `xml:"comment"`
+}
+
+type Schema struct {
+	object
+
+	FillColor string `xml:"fill-color,attr"`
+}
+
+type Extension struct {
+	schemaObject
+
+	Version string `xml:"cur-version,attr"`
+}
+
+// Type is a user defined type, such as an enumeration or a composite type.
+type Type struct {
+	schemaObject
+
+	Configuration string          `xml:"configuration,attr"`
+	Enumerations  *Enumerations   `xml:"enumerations"`
+	Attributes    []TypeAttribute `xml:"typeattrib"`
+}
+
+const (
+	TypeConfigurationEnumeration = "enumeration"
+	TypeConfigurationComposite   = "composite"
+	TypeConfigurationRange       = "range"
+	TypeConfigurationBase        = "base"
+)
+
+type Enumerations struct {
+	Values string `xml:"values,attr"`
+}
+
+type TypeAttribute struct {
+	Name string     `xml:"name,attr"`
+	Type ColumnType `xml:"type"`
+}
+
+type Domain struct {
+	schemaObject
+
+	NotNull      bool         `xml:"not-null,attr"`
+	DefaultValue string       `xml:"default-value,attr"`
+	BaseType     ColumnType   `xml:"type"`
+	Constraints  []Constraint `xml:"constraint"`
+}
+
+type Sequence struct {
+	schemaObject
+
+	Cycle     bool   `xml:"cycle,attr"`
+	Start     string `xml:"start,attr"`
+	Increment string `xml:"increment,attr"`
+	MinValue  string `xml:"min-value,attr"`
+	MaxValue  string `xml:"max-value,attr"`
+	Cache     string `xml:"cache,attr"`
+	// OwnerColumnRef is the schema qualified column that owns the sequence, if any.
+	OwnerColumnRef string  `xml:"owner-col,attr"`
+	OwnerColumn    *Column `xml:"-"`
+}
+
+type Table struct {
+	schemaObject
+
+	Unlogged    bool          `xml:"unlogged,attr"`
+	RLSEnabled  bool          `xml:"rls-enabled,attr"`
+	RLSForced   bool          `xml:"rls-forced,attr"`
+	Columns     []*Column     `xml:"column"`
+	Constraints []*Constraint `xml:"constraint"`
+	// Indexes and Triggers may be nested in the table or refer to it from the top level of the model.
+	Indexes  []*Index   `xml:"index"`
+	Triggers []*Trigger `xml:"trigger"`
+}
+
+// Column returns the column with the given name, or nil if it doesn't exist.
+func (t *Table) Column(name string) *Column {
+	for _, column := range t.Columns {
+		if column.Name == name {
+			return column
+		}
+	}
+
+	return nil
+}
+
+// PrimaryKey returns the primary key constraint, or nil if the table has none.
+func (t *Table) PrimaryKey() *Constraint {
+	for _, constraint := range t.Constraints {
+		if constraint.Type == ConstraintTypePrimaryKey {
+			return constraint
+		}
+	}
+
+	return nil
+}
+
+// ForeignKeys returns the foreign key constraints of the table.
+func (t *Table) ForeignKeys() []*Constraint {
+	var foreignKeys []*Constraint
+	for _, constraint := range t.Constraints {
+		if constraint.Type == ConstraintTypeForeignKey {
+			foreignKeys = append(foreignKeys, constraint)
+		}
+	}
+
+	return foreignKeys
+}
+
+type Column struct {
+	Name         string     `xml:"name,attr"`
+	NotNull      bool       `xml:"not-null,attr"`
+	DefaultValue string     `xml:"default-value,attr"`
+	IdentityType string     `xml:"identity-type,attr"`
+	Generated    bool       `xml:"generated,attr"`
+	SequenceRef  string     `xml:"sequence,attr"`
+	Type         ColumnType `xml:"type"`
+	RawComment   string     `xml:"comment"`
+
+	Table *Table `xml:"-"`
+	// Sequence is the sequence of the default value, if any.
+	Sequence *Sequence `xml:"-"`
+}
+
+// Comment returns the comment of the column without the whitespace pgModeler puts around it.
+func (c *Column) Comment() string {
+	return trimCDATA(c.RawComment)
+}
+
+// HasDefault reports whether the column gets a value if none is given.
+func (c *Column) HasDefault() bool {
+	return c.DefaultValue != "" || c.SequenceRef != "" || c.IdentityType != "" || c.Generated
+}
+
+// ColumnType is the data type of a column, a type attribute or a domain.
+type ColumnType struct {
+	Name string `xml:"name,attr"`
+	// Length and Precision are unset if they are 0 or less.
+	Length       int  `xml:"length,attr"`
+	Precision    int  `xml:"precision,attr"`
+	Dimension    int  `xml:"dimension,attr"`
+	WithTimezone bool `xml:"with-timezone,attr"`
+
+	// Type is the user defined type, nil for built-in types.
+	Type *Type `xml:"-"`
+	// Domain is the domain, nil for other types.
+	Domain *Domain `xml:"-"`
+}
+
+const (
+	ConstraintTypePrimaryKey = "pk-constr"
+	ConstraintTypeForeignKey = "fk-constr"
+	ConstraintTypeUnique     = "uq-constr"
+	ConstraintTypeCheck      = "ck-constr"
+	ConstraintTypeExclude    = "ex-constr"
+)
+
+const (
+	columnsRefTypeSource      = "src-columns"
+	columnsRefTypeDestination = "dst-columns"
+)
+
+type Constraint struct {
+	Name        string           `xml:"name,attr"`
+	Type        string           `xml:"type,attr"`
+	TableRef    string           `xml:"table,attr"`
+	RefTableRef string           `xml:"ref-table,attr"`
+	Deferrable  bool             `xml:"deferrable,attr"`
+	UpdAction   string           `xml:"upd-action,attr"`
+	DelAction   string           `xml:"del-action,attr"`
+	ColumnRefs  []ConstraintRefs `xml:"columns"`
+	Expression  string           `xml:"expression"`
+	RawComment  string           `xml:"comment"`
+	SQLDisabled bool             `xml:"sql-disabled,attr"`
+
+	Table *Table `xml:"-"`
+	// Columns are the columns of the table the constraint covers.
+	Columns []*Column `xml:"-"`
+	// RefTable and RefColumns are the referenced table and columns of a foreign key.
+	RefTable   *Table    `xml:"-"`
+	RefColumns []*Column `xml:"-"`
+}
+
+// Comment returns the comment of the constraint without the whitespace pgModeler puts around it.
+func (c *Constraint) Comment() string {
+	return trimCDATA(c.RawComment)
+}
+
+// ConstraintRefs lists columns by name. RefType tells whether they belong to the table or the referenced table.
+type ConstraintRefs struct {
+	Names   string `xml:"names,attr"`
+	RefType string `xml:"ref-type,attr"`
+}
+
+type Index struct {
+	Name       string         `xml:"name,attr"`
+	TableRef   string         `xml:"table,attr"`
+	Unique     bool           `xml:"unique,attr"`
+	Concurrent bool           `xml:"concurrent,attr"`
+	IndexType  string         `xml:"index-type,attr"`
+	Elements   []IndexElement `xml:"idxelement"`
+	Predicate  string         `xml:"predicate"`
+	RawComment string         `xml:"comment"`
+	LayerIDs   string         `xml:"layers,attr"`
+
+	Table *Table `xml:"-"`
+	// Columns are the columns of the elements in order. Elements on an expression have a nil column.
+	Columns []*Column `xml:"-"`
+}
+
+// Comment returns the comment of the index without the whitespace pgModeler puts around it.
+func (i *Index) Comment() string {
+	return trimCDATA(i.RawComment)
+}
+
+type IndexElement struct {
+	Column     *Reference `xml:"column"`
+	Expression string     `xml:"expression"`
+	AscOrder   bool       `xml:"asc-order,attr"`
+	NullsFirst bool       `xml:"nulls-first,attr"`
+}
+
+type Function struct {
+	schemaObject
+
+	FunctionType string       `xml:"function-type,attr"`
+	SecurityType string       `xml:"security-type,attr"`
+	Language     Reference    `xml:"language"`
+	ReturnType   *ReturnType  `xml:"return-type"`
+	Parameters   []*Parameter `xml:"parameter"`
+	RawBody      string       `xml:"definition"`
+}
+
+// Signature returns the schema qualified name and parameter types, as used by pgModeler to refer to the function.
+func (f *Function) Signature() string {
+	types := ""
+	for index, parameter := range f.Parameters {
+		if index > 0 {
+			types += ","
+		}
+		types += parameter.Type.String()
+	}
+
+	return f.QualifiedName() + "(" + types + ")"
+}
+
+// Body returns the definition of the function.
+func (f *Function) Body() string {
+	return trimCDATA(f.RawBody)
+}
+
+type ReturnType struct {
+	Type *ColumnType `xml:"type"`
+}
+
+type Parameter struct {
+	Name         string     `xml:"name,attr"`
+	In           bool       `xml:"in,attr"`
+	Out          bool       `xml:"out,attr"`
+	DefaultValue string     `xml:"default-value,attr"`
+	Type         ColumnType `xml:"type"`
+}
+
+type Trigger struct {
+	Name        string    `xml:"name,attr"`
+	TableRef    string    `xml:"table,attr"`
+	FiringType  string    `xml:"firing-type,attr"`
+	PerRow      bool      `xml:"per-line,attr"`
+	Insert      bool      `xml:"ins-event,attr"`
+	Delete      bool      `xml:"del-event,attr"`
+	Update      bool      `xml:"upd-event,attr"`
+	Truncate    bool      `xml:"trunc-event,attr"`
+	FunctionRef Signature `xml:"function"`
+	ColumnRefs  *Names    `xml:"columns"`
+	Condition   string    `xml:"condition"`
+	RawComment  string    `xml:"comment"`
+	SQLDisabled bool      `xml:"sql-disabled,attr"`
+
+	Table    *Table    `xml:"-"`
+	Function *Function `xml:"-"`
+	// Columns are the columns of an UPDATE OF trigger.
+	Columns []*Column `xml:"-"`
+}
+
+// Comment returns the comment of the trigger without the whitespace pgModeler puts around it.
+func (t *Trigger) Comment() string {
+	return trimCDATA(t.RawComment)
+}
+
+type Signature struct {
+	Signature string `xml:"signature,attr"`
+}
+
+// Names is an element that lists objects by name, separated by commas.
+type Names struct {
+	Names string `xml:"names,attr"`
+}
+
+type View struct {
+	schemaObject
+
+	Materialized  bool   `xml:"materialized,attr"`
+	Recursive     bool   `xml:"recursive,attr"`
+	RawDefinition string `xml:"definition"`
+}
+
+// Definition returns the query of the view.
+func (v *View) Definition() string {
+	return trimCDATA(v.RawDefinition)
+}
+
+// Relationship connects two tables. Only their names are parsed, columns added
+// by relationships are not part of the tables.
+type Relationship struct {
+	Name        string `xml:"name,attr"`
+	Type        string `xml:"type,attr"`
+	SrcTableRef string `xml:"src-table,attr"`
+	DstTableRef string `xml:"dst-table,attr"`
+}
+
+// Permission grants privileges on an object. A permission without roles grants them to PUBLIC.
+type Permission struct {
+	ObjectRef  PermissionObject `xml:"object"`
+	RoleRefs   *Names           `xml:"roles"`
+	Privileges Privileges       `xml:"privileges"`
+	Revoke     bool             `xml:"revoke,attr"`
+	Cascade    bool             `xml:"cascade,attr"`
+
+	Roles []*Role `xml:"-"`
+	// Object is the object the privileges are granted on, e.g. a *Table or a *Schema. It is nil for objects that are
+	// not part of the model.
+	Object any `xml:"-"`
+}
+
+type PermissionObject struct {
+	Name string `xml:"name,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// Privileges holds "true" for granted privileges and "grant-op" for privileges granted WITH GRANT OPTION.
+type Privileges struct {
+	Select     string `xml:"select,attr"`
+	Insert     string `xml:"insert,attr"`
+	Update     string `xml:"update,attr"`
+	Delete     string `xml:"delete,attr"`
+	Truncate   string `xml:"truncate,attr"`
+	References string `xml:"references,attr"`
+	Trigger    string `xml:"trigger,attr"`
+	Create     string `xml:"create,attr"`
+	Connect    string `xml:"connect,attr"`
+	Temporary  string `xml:"temporary,attr"`
+	Execute    string `xml:"execute,attr"`
+	Usage      string `xml:"usage,attr"`
 }
@@ -0,0 +1,410 @@
+package dbm
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ReadFile reads and parses the model file.
+func ReadFile(path string) (*DBModel, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model file: %w", err)
+	}
+
+	return Parse(data)
+}
+
+// Parse parses a model and resolves the references between its objects.
+// References to objects that are not part of the model, such as the postgres
+// role or built-in types, resolve to nil.
+func Parse(data []byte) (*DBModel, error) {
+	model := &DBModel{}
+
+	err := xml.Unmarshal(data, model)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse model: %w", err)
+	}
+
+	model.resolve()
+
+	return model, nil
+}
+
+// Schema returns the schema with the given name, or nil if it doesn't exist.
+func (m *DBModel) Schema(name string) *Schema {
+	for _, schema := range m.Schemas {
+		if schema.Name == name {
+			return schema
+		}
+	}
+
+	return nil
+}
+
+// Table returns the table with the given schema qualified name, or nil if it doesn't exist.
+func (m *DBModel) Table(name string) *Table {
+	for _, table := range m.Tables {
+		if table.QualifiedName() == name {
+			return table
+		}
+	}
+
+	return nil
+}
+
+// Sequence returns the sequence with the given schema qualified name, or nil if it doesn't exist.
+func (m *DBModel) Sequence(name string) *Sequence {
+	for _, sequence := range m.Sequences {
+		if sequence.QualifiedName() == name {
+			return sequence
+		}
+	}
+
+	return nil
+}
+
+// View returns the view with the given schema qualified name, or nil if it doesn't exist.
+func (m *DBModel) View(name string) *View {
+	for _, view := range m.Views {
+		if view.QualifiedName() == name {
+			return view
+		}
+	}
+
+	return nil
+}
+
+// Function returns the function with the given signature, e.g. public.f(integer).
+// If no signature matches exactly, a function with the same name is returned
+// if it is the only one with that name.
+func (m *DBModel) Function(signature string) *Function {
+	name, _, _ := strings.Cut(signature, "(")
+
+	var candidates []*Function
+	for _, function := range m.Functions {
+		if function.Signature() == signature {
+			return function
+		}
+		if function.QualifiedName() == name {
+			candidates = append(candidates, function)
+		}
+	}
+
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+
+	return nil
+}
+
+func (m *DBModel) role(name string) *Role {
+	for index := range m.Roles {
+		if m.Roles[index].Name == name {
+			return &m.Roles[index]
+		}
+	}
+
+	return nil
+}
+
+func (m *DBModel) tag(name string) *Tag {
+	for _, tag := range m.Tags {
+		if tag.Name == name {
+			return tag
+		}
+	}
+
+	return nil
+}
+
+//nolint:gocognit,cyclop
+func (m *DBModel) resolve() {
+	m.Layers = splitNames(m.LayerNames)
+
+	for _, schema := range m.Schemas {
+		m.resolveObject(&schema.object)
+	}
+
+	for _, extension := range m.Extensions {
+		m.resolveSchemaObject(&extension.schemaObject)
+	}
+
+	for _, userType := range m.Types {
+		m.resolveSchemaObject(&userType.schemaObject)
+	}
+
+	for _, domain := range m.Domains {
+		m.resolveSchemaObject(&domain.schemaObject)
+	}
+
+	for _, sequence := range m.Sequences {
+		m.resolveSchemaObject(&sequence.schemaObject)
+	}
+
+	for _, table := range m.Tables {
+		m.resolveSchemaObject(&table.schemaObject)
+	}
+
+	// Types are resolved once all user types and domains are known
+	for _, userType := range m.Types {
+		for index := range userType.Attributes {
+			m.resolveType(&userType.Attributes[index].Type)
+		}
+	}
+
+	for _, domain := range m.Domains {
+		m.resolveType(&domain.BaseType)
+	}
+
+	for _, table := range m.Tables {
+		for _, column := range table.Columns {
+			column.Table = table
+			m.resolveType(&column.Type)
+			if column.SequenceRef != "" {
+				column.Sequence = m.Sequence(column.SequenceRef)
+			}
+		}
+	}
+
+	for _, sequence := range m.Sequences {
+		if sequence.OwnerColumnRef != "" {
+			sequence.OwnerColumn = m.column(sequence.OwnerColumnRef)
+		}
+	}
+
+	// Constraints, indexes and triggers on the top level are added to their table
+	for _, constraint := range m.Constraints {
+		if table := m.Table(constraint.TableRef); table != nil {
+			table.Constraints = append(table.Constraints, constraint)
+		}
+	}
+
+	for _, index := range m.Indexes {
+		if table := m.Table(index.TableRef); table != nil {
+			table.Indexes = append(table.Indexes, index)
+		}
+	}
+
+	for _, trigger := range m.Triggers {
+		if table := m.Table(trigger.TableRef); table != nil {
+			table.Triggers = append(table.Triggers, trigger)
+		}
+	}
+
+	for _, table := range m.Tables {
+		for _, constraint := range table.Constraints {
+			m.resolveConstraint(table, constraint)
+		}
+
+		for _, index := range table.Indexes {
+			index.Table = table
+			index.Predicate = trimCDATA(index.Predicate)
+			for _, element := range index.Elements {
+				var column *Column
+				if element.Column != nil {
+					column = table.Column(element.Column.Name)
+				}
+				index.Columns = append(index.Columns, column)
+			}
+		}
+
+		for _, trigger := range table.Triggers {
+			trigger.Table = table
+			trigger.Condition = trimCDATA(trigger.Condition)
+			trigger.Function = m.Function(trigger.FunctionRef.Signature)
+			if trigger.ColumnRefs != nil {
+				trigger.Columns = columns(table, trigger.ColumnRefs.Names)
+			}
+		}
+	}
+
+	for _, view := range m.Views {
+		m.resolveSchemaObject(&view.schemaObject)
+	}
+
+	for _, function := range m.Functions {
+		m.resolveSchemaObject(&function.schemaObject)
+		if function.ReturnType != nil && function.ReturnType.Type != nil {
+			m.resolveType(function.ReturnType.Type)
+		}
+		for _, parameter := range function.Parameters {
+			m.resolveType(&parameter.Type)
+		}
+	}
+
+	for _, permission := range m.Permissions {
+		if permission.RoleRefs != nil {
+			for _, name := range splitNames(permission.RoleRefs.Names) {
+				if role := m.role(name); role != nil {
+					permission.Roles = append(permission.Roles, role)
+				}
+			}
+		}
+		permission.Object = m.permissionObject(permission.ObjectRef)
+	}
+}
+
+func (m *DBModel) resolveObject(o *object) {
+	if o.OwnerRef != nil {
+		o.Owner = m.role(o.OwnerRef.Name)
+	}
+	if o.TagRef != nil {
+		o.Tag = m.tag(o.TagRef.Name)
+	}
+
+	for _, id := range splitNames(o.LayerIDs) {
+		index, err := strconv.Atoi(id)
+		if err == nil && index >= 0 && index < len(m.Layers) {
+			o.Layers = append(o.Layers, m.Layers[index])
+		}
+	}
+}
+
+func (m *DBModel) resolveSchemaObject(o *schemaObject) {
+	m.resolveObject(&o.object)
+	o.Schema = m.Schema(o.SchemaRef.Name)
+}
+
+func (m *DBModel) resolveType(t *ColumnType) {
+	for _, userType := range m.Types {
+		if userType.QualifiedName() == t.Name {
+			t.Type = userType
+		}
+	}
+
+	for _, domain := range m.Domains {
+		if domain.QualifiedName() == t.Name {
+			t.Domain = domain
+		}
+	}
+}
+
+func (m *DBModel) resolveConstraint(table *Table, constraint *Constraint) {
+	constraint.Table = table
+	constraint.Expression = trimCDATA(constraint.Expression)
+
+	if constraint.RefTableRef != "" {
+		constraint.RefTable = m.Table(constraint.RefTableRef)
+	}
+
+	for _, refs := range constraint.ColumnRefs {
+		switch refs.RefType {
+		case columnsRefTypeSource:
+			constraint.Columns = columns(table, refs.Names)
+		case columnsRefTypeDestination:
+			if constraint.RefTable != nil {
+				constraint.RefColumns = columns(constraint.RefTable, refs.Names)
+			}
+		}
+	}
+}
+
+// column returns the column of a schema qualified column name such as public.users.id.
+func (m *DBModel) column(name string) *Column {
+	index := strings.LastIndex(name, ".")
+	if index == -1 {
+		return nil
+	}
+
+	table := m.Table(name[:index])
+	if table == nil {
+		return nil
+	}
+
+	return table.Column(name[index+1:])
+}
+
+func (m *DBModel) permissionObject(ref PermissionObject) any {
+	switch ref.Type {
+	case "schema":
+		if schema := m.Schema(ref.Name); schema != nil {
+			return schema
+		}
+	case "table":
+		if table := m.Table(ref.Name); table != nil {
+			return table
+		}
+	case "view":
+		if view := m.View(ref.Name); view != nil {
+			return view
+		}
+	case "sequence":
+		if sequence := m.Sequence(ref.Name); sequence != nil {
+			return sequence
+		}
+	case "function":
+		if function := m.Function(ref.Name); function != nil {
+			return function
+		}
+	case "column":
+		if column := m.column(ref.Name); column != nil {
+			return column
+		}
+	}
+
+	return nil
+}
+
+// columns returns the columns of the table listed in names. Columns that
+// don't exist, such as columns added by relationships, are skipped.
+func columns(table *Table, names string) []*Column {
+	var result []*Column
+	for _, name := range splitNames(names) {
+		if column := table.Column(name); column != nil {
+			result = append(result, column)
+		}
+	}
+
+	return result
+}
+
+// String returns the type as it is written in SQL, e.g. varchar(255)[].
+func (t ColumnType) String() string {
+	s := t.Name
+	if t.Length > 0 {
+		if t.Precision > 0 {
+			s += fmt.Sprintf("(%d,%d)", t.Length, t.Precision)
+		} else {
+			s += fmt.Sprintf("(%d)", t.Length)
+		}
+	}
+	if t.WithTimezone {
+		s += " with time zone"
+	}
+
+	return s + strings.Repeat("[]", max(t.Dimension, 0))
+}
+
+// Labels returns the labels of the enumeration in order.
+func (e *Enumerations) Labels() []string {
+	return splitNames(e.Values)
+}
+
+func qualifiedName(schema, name string) string {
+	if schema == "" {
+		return name
+	}
+
+	return schema + "." + name
+}
+
+func splitNames(names string) []string {
+	var result []string
+	for name := range strings.SplitSeq(names, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			result = append(result, name)
+		}
+	}
+
+	return result
+}
+
+// trimCDATA removes the whitespace pgModeler writes around CDATA sections.
+func trimCDATA(s string) string {
+	return strings.TrimSpace(s)
+}
@@ -5,26 +5,90 @@ import (
 	"database/sql"
 	"fmt"
 	"log"
+	"regexp"
 	"slices"
 	"strings"
+	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/stripe/pg-schema-diff/pkg/diff"
 	"github.com/stripe/pg-schema-diff/pkg/tempdb"
+
+	"github.com/printeers/trek/internal/configuration"
+)
+
+const (
+	diffMaxOpenConns = 100
+	// pgSchemaDiffDefaultTimeout is the statement and lock timeout pg-schema-diff uses unless a statement needs more.
+	pgSchemaDiffDefaultTimeout = 3 * time.Second
 )
 
-const diffMaxOpenConns = 100
+// regexpSchemaMigrationsTable matches statements that touch the table golang-migrate uses for bookkeeping.
+var regexpSchemaMigrationsTable = regexpTable("public", "schema_migrations")
+
+// regexpTable matches statements that reference the given table, either quoted or unquoted.
+func regexpTable(schema, table string) *regexp.Regexp {
+	return regexp.MustCompile(fmt.Sprintf(
+		`(^|[\s(])("%[1]s"|%[1]s)\.("%[2]s"|%[2]s)([\s(;]|$)`,
+		regexp.QuoteMeta(schema),
+		regexp.QuoteMeta(table),
+	))
+}
+
+type diffOptions struct {
+	timeouts           *configuration.Timeouts
+	concurrentIndexOps bool
+}
+
+// DiffOption configures the statements generated by Diff and DiffStatements.
+type DiffOption func(*diffOptions)
+
+// WithTimeouts overrides the statement and lock timeouts chosen by pg-schema-diff.
+func WithTimeouts(timeouts *configuration.Timeouts) DiffOption {
+	return func(o *diffOptions) {
+		o.timeouts = timeouts
+	}
+}
+
+// WithConcurrentIndexOps allows pg-schema-diff to create and drop indexes concurrently. The resulting statements can't
+// run in a transaction.
+func WithConcurrentIndexOps() DiffOption {
+	return func(o *diffOptions) {
+		o.concurrentIndexOps = true
+	}
+}
 
 // Diff generates a SQL script to migrate the schema from the 'from' database to match the 'to' database.
+func Diff(ctx context.Context, postgresConn, fromConn, toConn *pgx.Conn, opts ...DiffOption) (string, error) {
+	statements, err := DiffStatements(ctx, postgresConn, fromConn, toConn, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	return FormatStatements(statements), nil
+}
+
+// DiffStatements generates the statements to migrate the schema from the 'from' database to match the 'to' database.
 // nolint:gocognit,cyclop
-func Diff(ctx context.Context, postgresConn, fromConn, toConn *pgx.Conn) (string, error) {
+func DiffStatements(
+	ctx context.Context,
+	postgresConn,
+	fromConn,
+	toConn *pgx.Conn,
+	opts ...DiffOption,
+) ([]diff.Statement, error) {
+	options := &diffOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
 	fromDB := stdlib.OpenDB(*fromConn.Config())
 	fromDB.SetMaxOpenConns(diffMaxOpenConns)
 	defer fromDB.Close()
 	err := fromDB.PingContext(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to open 'from database' connection: %w", err)
+		return nil, fmt.Errorf("failed to open 'from database' connection: %w", err)
 	}
 
 	toDB := stdlib.OpenDB(*toConn.Config())
@@ -32,7 +96,7 @@ func Diff(ctx context.Context, postgresConn, fromConn, toConn *pgx.Conn) (string
 	defer toDB.Close()
 	err = toDB.PingContext(ctx)
 	if err != nil {
-		return "", fmt.Errorf("failed to open 'to database' connection: %w", err)
+		return nil, fmt.Errorf("failed to open 'to database' connection: %w", err)
 	}
 
 	var deferredCloseFuncs []func() error
@@ -61,29 +125,106 @@ func Diff(ctx context.Context, postgresConn, fromConn, toConn *pgx.Conn) (string
 		return tempDB, nil
 	})
 	if err != nil {
-		return "", fmt.Errorf("failed to create temp database factory: %w", err)
+		return nil, fmt.Errorf("failed to create temp database factory: %w", err)
+	}
+
+	planOptions := []diff.PlanOpt{
+		diff.WithTempDbFactory(tempFactory), // Required to validate the generated diff statements.
+		diff.WithDoNotValidatePlan(),        // See https://github.com/stripe/pg-schema-diff/issues/266
+	}
+	if !options.concurrentIndexOps {
+		// Concurrent index creation is not available in transactions.
+		planOptions = append(planOptions, diff.WithNoConcurrentIndexOps())
 	}
 
 	plan, err := diff.Generate(ctx,
 		diff.DBSchemaSource(fromDB),
 		diff.DBSchemaSource(toDB),
-		diff.WithTempDbFactory(tempFactory), // Required to validate the generated diff statements.
-		diff.WithNoConcurrentIndexOps(),     // Concurrent index creation is not available in transactions.
-		diff.WithDoNotValidatePlan(),        // See https://github.com/stripe/pg-schema-diff/issues/266
+		planOptions...,
 	)
 	if err != nil {
-		return "", fmt.Errorf("failed to generate diff plan: %w", err)
+		return nil, fmt.Errorf("failed to generate diff plan: %w", err)
 	}
 
-	// Ignore the proposed deletion of the schema_migrations table.
+	// Ignore the proposed changes to the schema_migrations table. It is dropped when diffing towards the model and
+	// created when diffing in reverse for down migrations.
 	plan.Statements = slices.DeleteFunc(plan.Statements, func(statement diff.Statement) bool {
-		return statement.DDL == `DROP TABLE "public"."schema_migrations"`
+		return regexpSchemaMigrationsTable.MatchString(statement.DDL)
 	})
 
+	if options.timeouts != nil {
+		plan = applyTimeouts(plan, options.timeouts)
+	}
+
+	return plan.Statements, nil
+}
+
+// applyTimeouts applies the default timeouts, then the overrides per hazard type and finally the overrides per table.
+// The defaults only replace the generic timeouts of pg-schema-diff, the longer timeouts it picks for statements such as
+// index builds are kept. When several overrides of the same kind match a statement, the longest timeout wins.
+func applyTimeouts(plan diff.Plan, timeouts *configuration.Timeouts) diff.Plan {
+	for i, stmt := range plan.Statements {
+		if timeouts.StatementTimeout > 0 && stmt.Timeout == pgSchemaDiffDefaultTimeout {
+			plan.Statements[i].Timeout = timeouts.StatementTimeout
+		}
+		if timeouts.LockTimeout > 0 && stmt.LockTimeout == pgSchemaDiffDefaultTimeout {
+			plan.Statements[i].LockTimeout = timeouts.LockTimeout
+		}
+	}
+
+	tables := make(map[*regexp.Regexp]configuration.Timeout, len(timeouts.Tables))
+	for table, timeout := range timeouts.Tables {
+		schema, name, found := strings.Cut(table, ".")
+		if !found {
+			schema, name = "public", table
+		}
+		tables[regexpTable(schema, name)] = timeout
+	}
+
+	for i, stmt := range plan.Statements {
+		var hazardOverrides []configuration.Timeout
+		for _, hazard := range stmt.Hazards {
+			if timeout, ok := timeouts.Hazards[hazard.Type]; ok {
+				hazardOverrides = append(hazardOverrides, timeout)
+			}
+		}
+		stmt = applyTimeoutOverrides(stmt, hazardOverrides)
+
+		var tableOverrides []configuration.Timeout
+		for regex, timeout := range tables {
+			if regex.MatchString(stmt.DDL) {
+				tableOverrides = append(tableOverrides, timeout)
+			}
+		}
+		plan.Statements[i] = applyTimeoutOverrides(stmt, tableOverrides)
+	}
+
+	return plan
+}
+
+func applyTimeoutOverrides(stmt diff.Statement, overrides []configuration.Timeout) diff.Statement {
+	var statementTimeout, lockTimeout time.Duration
+	for _, override := range overrides {
+		statementTimeout = max(statementTimeout, override.StatementTimeout)
+		lockTimeout = max(lockTimeout, override.LockTimeout)
+	}
+	if statementTimeout > 0 {
+		stmt.Timeout = statementTimeout
+	}
+	if lockTimeout > 0 {
+		stmt.LockTimeout = lockTimeout
+	}
+
+	return stmt
+}
+
+// FormatStatements renders the statements as SQL. Timeouts are set whenever they change and hazards are added as
+// comments above the statement they belong to.
+func FormatStatements(statements []diff.Statement) string {
 	sb := strings.Builder{}
 	var lastStatementTimeout int64
 	var lastLockTimeout int64
-	for i, stmt := range plan.Statements {
+	for i, stmt := range statements {
 		statementTimeout := stmt.Timeout.Milliseconds()
 		lockTimeout := stmt.LockTimeout.Milliseconds()
 		if lastStatementTimeout != statementTimeout || lastLockTimeout != lockTimeout {
@@ -109,10 +250,10 @@ func Diff(ctx context.Context, postgresConn, fromConn, toConn *pgx.Conn) (string
 			sb.WriteString("*/\n")
 		}
 		sb.WriteString(fmt.Sprintf("%s;\n", stmt.DDL))
-		if i < len(plan.Statements)-1 {
+		if i < len(statements)-1 {
 			sb.WriteString("\n")
 		}
 	}
 
-	return sb.String(), nil
+	return sb.String()
 }
@@ -33,3 +33,50 @@ func MarkFlagRequired(cmd *cobra.Command, flag string) {
 		log.Fatalf("Failed to mark flag %q as required: %v\n", flag, err)
 	}
 }
+
+// PostgresFlags are the flags used to connect to a running PostgreSQL server.
+type PostgresFlags struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	SSLMode  string
+}
+
+// Register adds the connection flags to the command and marks the required ones.
+func (f *PostgresFlags) Register(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&f.Host, "postgres-host", "", "Host of the PostgreSQL database")
+	cmd.Flags().IntVar(&f.Port, "postgres-port", 0, "Port of the PostgreSQL database")
+	cmd.Flags().StringVar(&f.User, "postgres-user", "", "User of the PostgreSQL database")
+	cmd.Flags().StringVar(&f.Password, "postgres-password", "", "Password of the PostgreSQL database")
+	cmd.Flags().StringVar(&f.SSLMode, "postgres-sslmode", "disable", "SSL Mode of the PostgreSQL database")
+	MarkFlagRequired(cmd, "postgres-host")
+	MarkFlagRequired(cmd, "postgres-port")
+	MarkFlagRequired(cmd, "postgres-user")
+	MarkFlagRequired(cmd, "postgres-password")
+}
+
+// DSN returns the connection string for the given database.
+func (f *PostgresFlags) DSN(database string) string {
+	return fmt.Sprintf(
+		"postgres://%s:%s@%s:%d/%s?sslmode=%s",
+		f.User,
+		f.Password,
+		f.Host,
+		f.Port,
+		database,
+		f.SSLMode,
+	)
+}
+
+// DevPostgresFlags are the flags of the PostgreSQL instance used to generate and check migrations.
+type DevPostgresFlags struct {
+	Port uint32
+	DSN  string
+}
+
+// Register adds the flags to the command.
+func (f *DevPostgresFlags) Register(cmd *cobra.Command) {
+	cmd.Flags().Uint32Var(&f.Port, "dev-postgres-port", 0, "Port of the embedded PostgreSQL instance, overrides the config. A free port is picked if not set") //nolint:lll
+	cmd.Flags().StringVar(&f.DSN, "dev-postgres-dsn", "", "DSN of an existing PostgreSQL server to use instead of the embedded instance, overrides the config") //nolint:lll
+}
@@ -0,0 +1,59 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// GitListFiles returns the names of the files in dir at the given ref. The
+// dir is relative to wd.
+func GitListFiles(ctx context.Context, wd, ref, dir string) ([]string, error) {
+	out, err := git(ctx, wd, "ls-tree", "--name-only", ref, dir+"/")
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for line := range strings.SplitSeq(strings.TrimSpace(out), "\n") {
+		if line != "" {
+			files = append(files, strings.TrimPrefix(line, dir+"/"))
+		}
+	}
+
+	return files, nil
+}
+
+// GitShowFile returns the content of the file at the given ref and whether it
+// exists there. The path is relative to wd.
+func GitShowFile(ctx context.Context, wd, ref, path string) (string, bool, error) {
+	files, err := git(ctx, wd, "ls-tree", "--name-only", ref, path)
+	if err != nil {
+		return "", false, err
+	}
+	if strings.TrimSpace(files) == "" {
+		return "", false, nil
+	}
+
+	content, err := git(ctx, wd, "show", fmt.Sprintf("%s:./%s", ref, path))
+	if err != nil {
+		return "", false, err
+	}
+
+	return content, true, nil
+}
+
+func git(ctx context.Context, wd string, args ...string) (string, error) {
+	cmdGit := exec.CommandContext(ctx, "git", args...)
+	cmdGit.Dir = wd
+	cmdGit.Stderr = os.Stderr
+
+	out, err := cmdGit.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to run git %s: %w", args[0], err)
+	}
+
+	return string(out), nil
+}
@@ -5,15 +5,30 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/printeers/trek/internal/configuration"
+	"github.com/printeers/trek/internal/psql"
 )
 
-func RunHook(ctx context.Context, wd, hookName string, options *HookOptions) error {
+// RunHook runs the hook, if it exists in the hooks directory. A hook is an
+// executable with the name of the hook, a SQL file with the name of the hook
+// and the .sql extension or both. The SQL file runs first, against the
+// database of the options. The timeout and error policy are taken from the
+// config.
+func RunHook(ctx context.Context, config *configuration.Config, wd, hookName string, options *HookOptions) error {
 	hooksDir := filepath.Join(wd, "hooks")
 	filePath := filepath.Join(hooksDir, hookName)
-	if _, err := os.Stat(filePath); errors.Is(err, os.ErrNotExist) {
+	sqlFilePath := filePath + ".sql"
+	hasExecutable := hookFileExists(filePath)
+	hasSQLFile := hookFileExists(sqlFilePath)
+	if !hasExecutable && !hasSQLFile {
 		log.Printf("Skipping hook %q", hookName)
 
 		return nil
@@ -21,25 +36,49 @@ func RunHook(ctx context.Context, wd, hookName string, options *HookOptions) err
 
 	log.Printf("Running hook %q", hookName)
 
-	var args []string
-	env := os.Environ()
-	if options != nil {
-		args = append(args, options.Args...)
+	if options == nil {
+		options = &HookOptions{}
+	}
 
-		var envValues []string
-		for key, value := range options.Env {
-			envValues = append(envValues, fmt.Sprintf("%s=%s", key, value))
-		}
-		env = append(env, envValues...)
+	settings := config.GetHookSettings(hookName)
+	if settings.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, settings.Timeout)
+		defer cancel()
+	}
+
+	var err error
+	if hasSQLFile {
+		err = runSQLHook(ctx, hookName, sqlFilePath, options.DSN)
+	}
+	if err == nil && hasExecutable {
+		err = runExecutableHook(ctx, hookName, wd, filePath, options)
 	}
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("hook %q timed out after %s: %w", hookName, settings.Timeout, ctx.Err())
+	}
+	if err != nil && settings.OnError == configuration.HookErrorPolicyIgnore {
+		log.Printf("WARNING: ignoring failed hook %q: %v\n", hookName, err)
+
+		return nil
+	}
+
+	return err
+}
 
-	cmd := exec.CommandContext(ctx, filePath, args...)
-	cmd.Env = env
-	cmd.Dir = hooksDir
+func runExecutableHook(ctx context.Context, hookName, wd, filePath string, options *HookOptions) error {
+	env, err := options.env(hookName, wd)
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, filePath, options.Args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Dir = filepath.Dir(filePath)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	err := cmd.Run()
+	err = cmd.Run()
 	if err != nil {
 		//nolint:wrapcheck
 		return err
@@ -48,7 +87,97 @@ func RunHook(ctx context.Context, wd, hookName string, options *HookOptions) err
 	return nil
 }
 
+// runSQLHook runs the SQL file with psql and stops at the first error.
+func runSQLHook(ctx context.Context, hookName, sqlFilePath, dsn string) error {
+	if dsn == "" {
+		//nolint:err113
+		return fmt.Errorf("hook %q has no database to run %s against", hookName, filepath.Base(sqlFilePath))
+	}
+
+	//nolint:wrapcheck
+	return psql.RunFile(ctx, dsn, sqlFilePath, os.Stdout)
+}
+
+// HookExists reports whether the hook has an executable or a SQL file in the hooks directory.
+func HookExists(wd, hookName string) bool {
+	filePath := filepath.Join(wd, "hooks", hookName)
+
+	return hookFileExists(filePath) || hookFileExists(filePath+".sql")
+}
+
+func hookFileExists(filePath string) bool {
+	_, err := os.Stat(filePath)
+
+	return !errors.Is(err, os.ErrNotExist)
+}
+
 type HookOptions struct {
 	Args []string
 	Env  map[string]string
+	// DSN of the database of the current phase. Its connection details are passed in the TREK_POSTGRES_* variables.
+	DSN string
+	// Migration is the step the migration-pre and migration-post hooks run around.
+	Migration *MigrationStep
+}
+
+// env returns the TREK_* variables of the hook, followed by the variables of the options.
+func (o *HookOptions) env(hookName, wd string) ([]string, error) {
+	values := map[string]string{
+		"TREK_HOOK":        hookName,
+		"TREK_WORKING_DIR": wd,
+	}
+
+	if o.DSN != "" {
+		postgresEnv, err := postgresHookEnv(o.DSN)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range postgresEnv {
+			values[key] = value
+		}
+	}
+
+	if o.Migration != nil {
+		direction := "up"
+		if !o.Migration.Up {
+			direction = "down"
+		}
+		values["TREK_MIGRATION_FILE"] = o.Migration.File
+		values["TREK_MIGRATION_VERSION"] = strconv.FormatUint(uint64(o.Migration.Version), 10)
+		values["TREK_MIGRATION_DIRECTION"] = direction
+	}
+
+	for key, value := range o.Env {
+		values[key] = value
+	}
+
+	env := make([]string, 0, len(values))
+	for key, value := range values {
+		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	}
+
+	return env, nil
+}
+
+// postgresHookEnv returns the environment variables that tell hooks how to connect to the database of the DSN.
+func postgresHookEnv(dsn string) (map[string]string, error) {
+	connConfig, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse dsn: %w", err)
+	}
+
+	sslMode := "prefer"
+	u, err := url.Parse(dsn)
+	if err == nil && u.Query().Has("sslmode") {
+		sslMode = u.Query().Get("sslmode")
+	}
+
+	return map[string]string{
+		"TREK_POSTGRES_HOST":     connConfig.Host,
+		"TREK_POSTGRES_PORT":     strconv.Itoa(int(connConfig.Port)),
+		"TREK_POSTGRES_USER":     connConfig.User,
+		"TREK_POSTGRES_PASSWORD": connConfig.Password,
+		"TREK_POSTGRES_DATABASE": connConfig.Database,
+		"TREK_POSTGRES_SSLMODE":  sslMode,
+	}, nil
 }
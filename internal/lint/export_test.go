@@ -0,0 +1,21 @@
+package lint
+
+// Statement is a statement as returned by splitStatements.
+type Statement struct {
+	Text       string
+	Line       int
+	Suppressed []string
+}
+
+func SplitStatements(sql string) []Statement {
+	var statements []Statement
+	for _, stmt := range splitStatements(sql) {
+		statements = append(statements, Statement{
+			Text:       stmt.text,
+			Line:       stmt.line,
+			Suppressed: stmt.suppressed,
+		})
+	}
+
+	return statements
+}
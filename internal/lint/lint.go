@@ -0,0 +1,67 @@
+package lint
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+
+	"github.com/printeers/trek/internal/configuration"
+)
+
+// Finding is a single violation of a rule.
+type Finding struct {
+	RuleID   string
+	Severity configuration.LintSeverity
+	// Object is the name of the offending object, e.g. public.users.id, or the file of a migration.
+	Object string
+	// Line is the line of the statement in the migration file, 0 for findings of the model.
+	Line    int
+	Message string
+}
+
+func (f Finding) String() string {
+	location := f.Object
+	if f.Line > 0 {
+		location = fmt.Sprintf("%s:%d", f.Object, f.Line)
+	}
+
+	return fmt.Sprintf("%s: %s: %s (%s)", location, f.Severity, f.Message, f.RuleID)
+}
+
+// Rule is a built-in check with a severity that applies unless the config overrides it.
+type Rule struct {
+	ID              string
+	Description     string
+	DefaultSeverity configuration.LintSeverity
+}
+
+// Rules returns all built-in rules sorted by ID.
+func Rules() []Rule {
+	rules := make([]Rule, 0, len(modelRules)+len(migrationRules))
+	for _, rule := range modelRules {
+		rules = append(rules, rule.Rule)
+	}
+	for _, rule := range migrationRules {
+		rules = append(rules, rule.Rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return rules[i].ID < rules[j].ID
+	})
+
+	return rules
+}
+
+// HasErrors reports whether any of the findings has the severity error.
+func HasErrors(findings []Finding) bool {
+	return slices.ContainsFunc(findings, func(finding Finding) bool {
+		return finding.Severity == configuration.LintSeverityError
+	})
+}
+
+func severity(config *configuration.Config, rule Rule) configuration.LintSeverity {
+	if s, ok := config.Lint[rule.ID]; ok {
+		return s
+	}
+
+	return rule.DefaultSeverity
+}
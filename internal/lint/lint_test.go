@@ -0,0 +1,22 @@
+package lint_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/printeers/trek/internal/configuration"
+	"github.com/printeers/trek/internal/lint"
+)
+
+func TestRulesMatchConfiguration(t *testing.T) {
+	t.Parallel()
+
+	ids := make([]string, 0, len(lint.Rules()))
+	for _, rule := range lint.Rules() {
+		ids = append(ids, rule.ID)
+	}
+
+	if !reflect.DeepEqual(ids, configuration.LintRuleIDs) {
+		t.Errorf("rule IDs\ngot  %q\nwant %q in configuration.LintRuleIDs", ids, configuration.LintRuleIDs)
+	}
+}
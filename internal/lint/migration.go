@@ -0,0 +1,245 @@
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/printeers/trek/internal/configuration"
+)
+
+// The expressions match normalized statements, see statement. Keywords are matched case insensitively.
+var (
+	regexpCreateTable     = regexp.MustCompile(`(?i)^CREATE (?:(?:GLOBAL |LOCAL )?(?:TEMPORARY |TEMP |UNLOGGED ))?TABLE (?:IF NOT EXISTS )?([^\s(]+)`) //nolint:lll
+	regexpAlterTable      = regexp.MustCompile(`(?i)^ALTER TABLE (?:IF EXISTS )?(?:ONLY )?(\S+) (.+)$`)
+	regexpAddColumn       = regexp.MustCompile(`(?i)^ADD (?:COLUMN )?(?:IF NOT EXISTS )?(\S+)`)
+	regexpDropColumn      = regexp.MustCompile(`(?i)^DROP (?:COLUMN )?(?:IF EXISTS )?(\S+)`)
+	regexpAlterColumnType = regexp.MustCompile(`(?i)^ALTER (?:COLUMN )?(\S+) (?:SET DATA )?TYPE `)
+	regexpUsing           = regexp.MustCompile(`(?i)\bUSING\b`)
+	regexpSetNotNull      = regexp.MustCompile(`(?i)^ALTER (?:COLUMN )?(\S+) SET NOT NULL$`)
+	regexpCreateIndex     = regexp.MustCompile(`(?i)^CREATE (?:UNIQUE )?INDEX (CONCURRENTLY )?(?:.*? )?ON (?:ONLY )?([^\s(]+)`) //nolint:lll
+	regexpVacuumFull      = regexp.MustCompile(`(?i)^VACUUM (?:FULL\b|\([^)]*\bFULL\b)`)
+)
+
+// tableConstraintKeywords start the ADD and DROP actions of ALTER TABLE that are not about columns.
+//
+//nolint:gochecknoglobals
+var tableConstraintKeywords = []string{"CONSTRAINT", "PRIMARY", "UNIQUE", "CHECK", "FOREIGN", "EXCLUDE"}
+
+func isTableConstraint(keyword string) bool {
+	return slices.Contains(tableConstraintKeywords, strings.ToUpper(keyword))
+}
+
+// migrationState holds what the statements of a migration have done so far.
+type migrationState struct {
+	// tables that have been created, they are empty, so most operations on them are safe.
+	tables map[string]bool
+	// columns that have been added, as table.column.
+	columns map[string]bool
+}
+
+// isNew reports whether the column has been added or its table has been created by the migration.
+func (s *migrationState) isNew(table, column string) bool {
+	return s.isNewTable(table) || s.columns[strings.ToLower(table+"."+column)]
+}
+
+func (s *migrationState) isNewTable(table string) bool {
+	return s.tables[strings.ToLower(table)]
+}
+
+// update records the tables and columns the statement creates.
+func (s *migrationState) update(stmt *statement) {
+	if match := regexpCreateTable.FindStringSubmatch(stmt.text); match != nil {
+		s.tables[strings.ToLower(match[1])] = true
+	}
+
+	table, actions := alterTableActions(stmt)
+	for _, action := range actions {
+		match := regexpAddColumn.FindStringSubmatch(action)
+		if match != nil && !isTableConstraint(match[1]) {
+			s.columns[strings.ToLower(table+"."+match[1])] = true
+		}
+	}
+}
+
+type migrationRule struct {
+	Rule
+	// check returns a message per violation in the statement.
+	check func(stmt *statement, state *migrationState) []string
+}
+
+//nolint:gochecknoglobals
+var migrationRules = []migrationRule{
+	{
+		Rule: Rule{
+			ID:              "drop-column",
+			Description:     "Dropping a column deletes data and breaks clients that still use it",
+			DefaultSeverity: configuration.LintSeverityWarning,
+		},
+		check: func(stmt *statement, _ *migrationState) []string {
+			var messages []string
+			table, actions := alterTableActions(stmt)
+			for _, action := range actions {
+				match := regexpDropColumn.FindStringSubmatch(action)
+				if match != nil && !isTableConstraint(match[1]) {
+					messages = append(messages, fmt.Sprintf("drops column %s.%s", table, match[1]))
+				}
+			}
+
+			return messages
+		},
+	},
+	{
+		Rule: Rule{
+			ID:              "alter-column-type-without-using",
+			Description:     "Changing the type of a column without USING rewrites the table and may fail on existing rows",
+			DefaultSeverity: configuration.LintSeverityWarning,
+		},
+		check: func(stmt *statement, state *migrationState) []string {
+			var messages []string
+			table, actions := alterTableActions(stmt)
+			for _, action := range actions {
+				match := regexpAlterColumnType.FindStringSubmatch(action)
+				if match != nil && !regexpUsing.MatchString(action) && !state.isNew(table, match[1]) {
+					messages = append(messages, fmt.Sprintf("changes the type of column %s.%s without USING", table, match[1]))
+				}
+			}
+
+			return messages
+		},
+	},
+	{
+		Rule: Rule{
+			ID:              "set-not-null",
+			Description:     "Setting NOT NULL on an existing column scans the table under an ACCESS EXCLUSIVE lock",
+			DefaultSeverity: configuration.LintSeverityWarning,
+		},
+		check: func(stmt *statement, state *migrationState) []string {
+			var messages []string
+			table, actions := alterTableActions(stmt)
+			for _, action := range actions {
+				match := regexpSetNotNull.FindStringSubmatch(action)
+				if match != nil && !state.isNew(table, match[1]) {
+					messages = append(messages, fmt.Sprintf("sets NOT NULL on existing column %s.%s", table, match[1]))
+				}
+			}
+
+			return messages
+		},
+	},
+	{
+		Rule: Rule{
+			ID:              "non-concurrent-index",
+			Description:     "Building an index without CONCURRENTLY blocks writes to the table",
+			DefaultSeverity: configuration.LintSeverityWarning,
+		},
+		check: func(stmt *statement, state *migrationState) []string {
+			match := regexpCreateIndex.FindStringSubmatch(stmt.text)
+			if match == nil || match[1] != "" || state.isNewTable(match[2]) {
+				return nil
+			}
+
+			return []string{fmt.Sprintf("builds an index on %s without CONCURRENTLY", match[2])}
+		},
+	},
+	{
+		Rule: Rule{
+			ID:              "vacuum-full",
+			Description:     "VACUUM FULL rewrites the table under an ACCESS EXCLUSIVE lock",
+			DefaultSeverity: configuration.LintSeverityWarning,
+		},
+		check: func(stmt *statement, _ *migrationState) []string {
+			if !regexpVacuumFull.MatchString(stmt.text) {
+				return nil
+			}
+
+			return []string{"runs VACUUM FULL"}
+		},
+	},
+}
+
+// Migration runs the migration rules that are not switched off in the config
+// against the statements of an up migration. The findings refer to the file
+// and the line the statement starts on.
+func Migration(config *configuration.Config, file, sql string) []Finding {
+	state := &migrationState{
+		tables:  map[string]bool{},
+		columns: map[string]bool{},
+	}
+
+	var findings []Finding
+	for _, stmt := range splitStatements(sql) {
+		state.update(stmt)
+
+		for _, id := range unknownSuppressions(stmt) {
+			findings = append(findings, Finding{
+				RuleID:   SuppressionMarker,
+				Severity: configuration.LintSeverityWarning,
+				Object:   file,
+				Line:     stmt.line,
+				Message:  fmt.Sprintf("suppresses unknown migration rule %q", id),
+			})
+		}
+
+		for _, rule := range migrationRules {
+			s := severity(config, rule.Rule)
+			if s == configuration.LintSeverityOff || stmt.isSuppressed(rule.ID) {
+				continue
+			}
+
+			for _, message := range rule.check(stmt, state) {
+				findings = append(findings, Finding{
+					RuleID:   rule.ID,
+					Severity: s,
+					Object:   file,
+					Line:     stmt.line,
+					Message:  message,
+				})
+			}
+		}
+	}
+
+	return findings
+}
+
+// unknownSuppressions returns the rule IDs of the suppression comments of the statement that are not migration rules.
+func unknownSuppressions(stmt *statement) []string {
+	var unknown []string
+	for _, id := range stmt.suppressed {
+		isRule := slices.ContainsFunc(migrationRules, func(rule migrationRule) bool { return rule.ID == id })
+		if id != "" && !isRule && !slices.Contains(unknown, id) {
+			unknown = append(unknown, id)
+		}
+	}
+
+	return unknown
+}
+
+// alterTableActions returns the table and the comma separated actions of an ALTER TABLE statement.
+func alterTableActions(stmt *statement) (string, []string) {
+	match := regexpAlterTable.FindStringSubmatch(stmt.text)
+	if match == nil {
+		return "", nil
+	}
+
+	var actions []string
+	depth := 0
+	start := 0
+	for index, c := range match[2] {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				actions = append(actions, strings.TrimSpace(match[2][start:index]))
+				start = index + 1
+			}
+		}
+	}
+	actions = append(actions, strings.TrimSpace(match[2][start:]))
+
+	return match[1], actions
+}
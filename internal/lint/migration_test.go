@@ -0,0 +1,230 @@
+package lint_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/printeers/trek/internal/configuration"
+	"github.com/printeers/trek/internal/lint"
+)
+
+const migrationFile = "001_test.up.sql"
+
+func warning(ruleID string, line int, message string) lint.Finding {
+	return lint.Finding{
+		RuleID:   ruleID,
+		Severity: configuration.LintSeverityWarning,
+		Object:   migrationFile,
+		Line:     line,
+		Message:  message,
+	}
+}
+
+func TestMigration(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		sql  string
+		want []lint.Finding
+	}{
+		{
+			name: "safe statements",
+			sql:  "CREATE TABLE a (id int);\nALTER TABLE b ADD COLUMN c text;\nCREATE INDEX CONCURRENTLY i ON b (c);\n",
+			want: nil,
+		},
+		{
+			name: "drop column",
+			sql:  "ALTER TABLE public.a DROP COLUMN b;\n",
+			want: []lint.Finding{warning("drop-column", 1, "drops column public.a.b")},
+		},
+		{
+			name: "drop column without COLUMN keyword and several actions",
+			sql:  "ALTER TABLE a DROP b, DROP IF EXISTS c;\n",
+			want: []lint.Finding{
+				warning("drop-column", 1, "drops column a.b"),
+				warning("drop-column", 1, "drops column a.c"),
+			},
+		},
+		{
+			name: "drop constraint",
+			sql:  "ALTER TABLE a DROP CONSTRAINT a_pk;\n",
+			want: nil,
+		},
+		{
+			name: "alter column type without using",
+			sql:  "ALTER TABLE a ALTER COLUMN b TYPE bigint;\n",
+			want: []lint.Finding{
+				warning("alter-column-type-without-using", 1, "changes the type of column a.b without USING"),
+			},
+		},
+		{
+			name: "alter column type with using",
+			sql:  "ALTER TABLE a ALTER COLUMN b SET DATA TYPE bigint USING b::bigint;\n",
+			want: nil,
+		},
+		{
+			name: "set not null",
+			sql:  "SELECT 1;\nALTER TABLE a ALTER COLUMN b SET NOT NULL;\n",
+			want: []lint.Finding{warning("set-not-null", 2, "sets NOT NULL on existing column a.b")},
+		},
+		{
+			name: "non-concurrent index",
+			sql:  "CREATE UNIQUE INDEX i ON public.a (b);\n",
+			want: []lint.Finding{
+				warning("non-concurrent-index", 1, "builds an index on public.a without CONCURRENTLY"),
+			},
+		},
+		{
+			name: "vacuum full",
+			sql:  "VACUUM (FULL, ANALYZE) a;\nVACUUM a;\n",
+			want: []lint.Finding{warning("vacuum-full", 1, "runs VACUUM FULL")},
+		},
+		{
+			name: "new table is exempt",
+			sql: "CREATE TABLE a (b int);\n" +
+				"ALTER TABLE a ALTER COLUMN b TYPE bigint, ALTER COLUMN b SET NOT NULL;\n" +
+				"CREATE INDEX i ON a (b);\n",
+			want: nil,
+		},
+		{
+			name: "new column is exempt",
+			sql:  "ALTER TABLE a ADD COLUMN b int;\nALTER TABLE a ALTER COLUMN b TYPE bigint, ALTER b SET NOT NULL;\n",
+			want: nil,
+		},
+		{
+			name: "other column of the table is not exempt",
+			sql:  "ALTER TABLE a ADD COLUMN b int;\nALTER TABLE a ALTER COLUMN c SET NOT NULL;\n",
+			want: []lint.Finding{warning("set-not-null", 2, "sets NOT NULL on existing column a.c")},
+		},
+		{
+			name: "statement in a comment",
+			sql:  "-- ALTER TABLE a DROP COLUMN b;\nSELECT 1;\n",
+			want: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := lint.Migration(&configuration.Config{}, migrationFile, tt.sql)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Migration(%q)\ngot  %+v\nwant %+v", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrationSeverity(t *testing.T) {
+	t.Parallel()
+
+	sql := "ALTER TABLE a DROP COLUMN b;\nVACUUM FULL a;\n"
+
+	tests := []struct {
+		name string
+		lint map[string]configuration.LintSeverity
+		want []lint.Finding
+	}{
+		{
+			name: "default",
+			lint: nil,
+			want: []lint.Finding{
+				warning("drop-column", 1, "drops column a.b"),
+				warning("vacuum-full", 2, "runs VACUUM FULL"),
+			},
+		},
+		{
+			name: "error",
+			lint: map[string]configuration.LintSeverity{"drop-column": configuration.LintSeverityError},
+			want: []lint.Finding{
+				{
+					RuleID:   "drop-column",
+					Severity: configuration.LintSeverityError,
+					Object:   migrationFile,
+					Line:     1,
+					Message:  "drops column a.b",
+				},
+				warning("vacuum-full", 2, "runs VACUUM FULL"),
+			},
+		},
+		{
+			name: "off",
+			lint: map[string]configuration.LintSeverity{"vacuum-full": configuration.LintSeverityOff},
+			want: []lint.Finding{warning("drop-column", 1, "drops column a.b")},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := lint.Migration(&configuration.Config{Lint: tt.lint}, migrationFile, sql)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Migration with %v\ngot  %+v\nwant %+v", tt.lint, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMigrationSuppressions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		sql  string
+		want []lint.Finding
+	}{
+		{
+			name: "suppressed rule",
+			sql:  "-- trek:lint-ignore drop-column\nALTER TABLE a DROP COLUMN b;\n",
+			want: nil,
+		},
+		{
+			name: "other rule is still reported",
+			sql:  "-- trek:lint-ignore set-not-null\nALTER TABLE a DROP COLUMN b;\n",
+			want: []lint.Finding{warning("drop-column", 2, "drops column a.b")},
+		},
+		{
+			name: "only the next statement",
+			sql:  "-- trek:lint-ignore drop-column\nALTER TABLE a DROP COLUMN b;\nALTER TABLE a DROP COLUMN c;\n",
+			want: []lint.Finding{warning("drop-column", 3, "drops column a.c")},
+		},
+		{
+			name: "all rules",
+			sql:  "ALTER TABLE a DROP COLUMN b, ALTER COLUMN c SET NOT NULL; -- trek:lint-ignore\n",
+			want: nil,
+		},
+		{
+			name: "several rules separated by commas and whitespace",
+			sql: "-- trek:lint-ignore drop-column,set-not-null vacuum-full\n" +
+				"ALTER TABLE a DROP COLUMN b, ALTER c SET NOT NULL;\n",
+			want: nil,
+		},
+		{
+			name: "unknown rule",
+			sql:  "SELECT 1;\n-- trek:lint-ignore drop-colum, drop-column\nALTER TABLE a DROP COLUMN b;\n",
+			want: []lint.Finding{
+				warning(lint.SuppressionMarker, 3, `suppresses unknown migration rule "drop-colum"`),
+			},
+		},
+		{
+			name: "model rule",
+			sql:  "/* trek:lint-ignore missing-primary-key missing-primary-key */\nCREATE TABLE a (b int);\n",
+			want: []lint.Finding{
+				warning(lint.SuppressionMarker, 2, `suppresses unknown migration rule "missing-primary-key"`),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := lint.Migration(&configuration.Config{}, migrationFile, tt.sql)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Migration(%q)\ngot  %+v\nwant %+v", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
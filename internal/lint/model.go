@@ -0,0 +1,255 @@
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+
+	"github.com/printeers/trek/internal/configuration"
+	"github.com/printeers/trek/internal/dbm"
+)
+
+var regexpSnakeCase = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+type modelRule struct {
+	Rule
+	check func(model *dbm.DBModel, report func(object, message string))
+}
+
+//nolint:gochecknoglobals
+var modelRules = []modelRule{
+	{
+		Rule: Rule{
+			ID:              "missing-primary-key",
+			Description:     "Tables must have a primary key",
+			DefaultSeverity: configuration.LintSeverityWarning,
+		},
+		check: func(model *dbm.DBModel, report func(object, message string)) {
+			for _, table := range tables(model) {
+				if table.PrimaryKey() == nil {
+					report(table.QualifiedName(), "table has no primary key")
+				}
+			}
+		},
+	},
+	{
+		Rule: Rule{
+			ID:              "foreign-key-without-index",
+			Description:     "The columns of a foreign key must be covered by the leading columns of an index",
+			DefaultSeverity: configuration.LintSeverityWarning,
+		},
+		check: func(model *dbm.DBModel, report func(object, message string)) {
+			for _, table := range tables(model) {
+				for _, foreignKey := range table.ForeignKeys() {
+					if foreignKey.SQLDisabled || len(foreignKey.Columns) == 0 || hasSupportingIndex(table, foreignKey) {
+						continue
+					}
+					report(
+						table.QualifiedName()+"."+foreignKey.Name,
+						fmt.Sprintf("foreign key on %s has no supporting index", columnNames(foreignKey.Columns)),
+					)
+				}
+			}
+		},
+	},
+	{
+		Rule: Rule{
+			ID:              "primary-key-name",
+			Description:     "Primary keys must be named <table>_pk",
+			DefaultSeverity: configuration.LintSeverityWarning,
+		},
+		check: func(model *dbm.DBModel, report func(object, message string)) {
+			for _, table := range tables(model) {
+				primaryKey := table.PrimaryKey()
+				if primaryKey != nil && primaryKey.Name != table.Name+"_pk" {
+					report(
+						table.QualifiedName()+"."+primaryKey.Name,
+						fmt.Sprintf("primary key must be named %q", table.Name+"_pk"),
+					)
+				}
+			}
+		},
+	},
+	{
+		Rule: Rule{
+			ID:              "sequence-name",
+			Description:     "Sequences of columns must be named seq_<table>_<column>",
+			DefaultSeverity: configuration.LintSeverityWarning,
+		},
+		check: func(model *dbm.DBModel, report func(object, message string)) {
+			for _, table := range tables(model) {
+				for _, column := range table.Columns {
+					if column.Sequence == nil {
+						continue
+					}
+					expected := fmt.Sprintf("seq_%s_%s", table.Name, column.Name)
+					if column.Sequence.Name != expected {
+						report(
+							column.Sequence.QualifiedName(),
+							fmt.Sprintf("sequence of %s.%s must be named %q", table.QualifiedName(), column.Name, expected),
+						)
+					}
+				}
+			}
+		},
+	},
+	{
+		Rule: Rule{
+			ID:              "snake-case-name",
+			Description:     "Names of schemas, tables, columns, constraints, indexes, sequences, views, functions and triggers must be snake_case", //nolint:lll
+			DefaultSeverity: configuration.LintSeverityWarning,
+		},
+		check: func(model *dbm.DBModel, report func(object, message string)) {
+			check := func(object, name string) {
+				if !regexpSnakeCase.MatchString(name) {
+					report(object, fmt.Sprintf("name %q is not snake_case", name))
+				}
+			}
+
+			for _, schema := range model.Schemas {
+				if !schema.SQLDisabled {
+					check(schema.Name, schema.Name)
+				}
+			}
+			for _, table := range tables(model) {
+				check(table.QualifiedName(), table.Name)
+				for _, column := range table.Columns {
+					check(table.QualifiedName()+"."+column.Name, column.Name)
+				}
+				for _, constraint := range table.Constraints {
+					check(table.QualifiedName()+"."+constraint.Name, constraint.Name)
+				}
+				for _, index := range table.Indexes {
+					check(table.QualifiedName()+"."+index.Name, index.Name)
+				}
+				for _, trigger := range table.Triggers {
+					check(table.QualifiedName()+"."+trigger.Name, trigger.Name)
+				}
+			}
+			for _, sequence := range model.Sequences {
+				if !sequence.SQLDisabled {
+					check(sequence.QualifiedName(), sequence.Name)
+				}
+			}
+			for _, view := range model.Views {
+				if !view.SQLDisabled {
+					check(view.QualifiedName(), view.Name)
+				}
+			}
+			for _, function := range model.Functions {
+				if !function.SQLDisabled {
+					check(function.Signature(), function.Name)
+				}
+			}
+		},
+	},
+	{
+		Rule: Rule{
+			ID:              "missing-comment",
+			Description:     "Tables and columns must have a comment",
+			DefaultSeverity: configuration.LintSeverityOff,
+		},
+		check: func(model *dbm.DBModel, report func(object, message string)) {
+			for _, table := range tables(model) {
+				if table.Comment() == "" {
+					report(table.QualifiedName(), "table has no comment")
+				}
+				for _, column := range table.Columns {
+					if column.Comment() == "" {
+						report(table.QualifiedName()+"."+column.Name, "column has no comment")
+					}
+				}
+			}
+		},
+	},
+	{
+		Rule: Rule{
+			ID:              "nullable-without-default",
+			Description:     "Nullable columns must have a default value",
+			DefaultSeverity: configuration.LintSeverityOff,
+		},
+		check: func(model *dbm.DBModel, report func(object, message string)) {
+			for _, table := range tables(model) {
+				for _, column := range table.Columns {
+					if !column.NotNull && !column.HasDefault() {
+						report(table.QualifiedName()+"."+column.Name, "nullable column has no default value")
+					}
+				}
+			}
+		},
+	},
+}
+
+// Model runs the model rules that are not switched off in the config.
+func Model(config *configuration.Config, model *dbm.DBModel) []Finding {
+	var findings []Finding
+	for _, rule := range modelRules {
+		s := severity(config, rule.Rule)
+		if s == configuration.LintSeverityOff {
+			continue
+		}
+
+		rule.check(model, func(object, message string) {
+			findings = append(findings, Finding{
+				RuleID:   rule.ID,
+				Severity: s,
+				Object:   object,
+				Message:  message,
+			})
+		})
+	}
+
+	return findings
+}
+
+// tables returns the tables for which SQL is generated.
+func tables(model *dbm.DBModel) []*dbm.Table {
+	var result []*dbm.Table
+	for _, table := range model.Tables {
+		if !table.SQLDisabled {
+			result = append(result, table)
+		}
+	}
+
+	return result
+}
+
+// hasSupportingIndex reports whether the leading columns of an index, the primary
+// key or a unique constraint of the table are the columns of the foreign key.
+func hasSupportingIndex(table *dbm.Table, foreignKey *dbm.Constraint) bool {
+	var candidates [][]*dbm.Column
+	for _, index := range table.Indexes {
+		candidates = append(candidates, index.Columns)
+	}
+	for _, constraint := range table.Constraints {
+		if constraint.Type == dbm.ConstraintTypePrimaryKey || constraint.Type == dbm.ConstraintTypeUnique {
+			candidates = append(candidates, constraint.Columns)
+		}
+	}
+
+	for _, columns := range candidates {
+		if len(columns) < len(foreignKey.Columns) {
+			continue
+		}
+		leading := columns[:len(foreignKey.Columns)]
+		if !slices.ContainsFunc(foreignKey.Columns, func(column *dbm.Column) bool {
+			return !slices.Contains(leading, column)
+		}) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func columnNames(columns []*dbm.Column) string {
+	names := ""
+	for index, column := range columns {
+		if index > 0 {
+			names += ", "
+		}
+		names += column.Name
+	}
+
+	return names
+}
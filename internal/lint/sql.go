@@ -0,0 +1,266 @@
+package lint
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// SuppressionMarker starts a comment that suppresses findings of a statement, e.g.
+// "-- trek:lint-ignore drop-column". The rule IDs are separated by whitespace
+// or commas. Without rule IDs it suppresses all rules.
+// On a line of its own it applies to the next statement, otherwise to the
+// statement on the same line.
+const SuppressionMarker = "trek:lint-ignore"
+
+var regexpWhitespace = regexp.MustCompile(`\s+`)
+
+// statement is a single statement of a SQL file.
+type statement struct {
+	// text is the normalized statement: comments are removed, string literals
+	// and dollar quoted bodies are emptied, quotes of identifiers are removed
+	// and whitespace is collapsed.
+	text    string
+	line    int
+	endLine int
+	// suppressed holds the rule IDs of the suppression comments, an empty ID suppresses all rules.
+	suppressed []string
+}
+
+func (s *statement) isSuppressed(ruleID string) bool {
+	for _, id := range s.suppressed {
+		if id == "" || id == ruleID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitter splits SQL into statements. It knows enough about comments, string
+// literals, quoted identifiers and dollar quotes to find the semicolons that end
+// statements, it doesn't parse the statements.
+type splitter struct {
+	sql        []rune
+	pos        int
+	line       int
+	statements []*statement
+
+	current     strings.Builder
+	currentLine int
+	// lineHasCode is set once the current line has something other than whitespace or comments.
+	lineHasCode bool
+	// pending holds the suppressions of comments on their own line until the next statement starts.
+	pending []string
+}
+
+func splitStatements(sql string) []*statement {
+	s := &splitter{sql: []rune(sql), line: 1}
+	s.split()
+
+	return s.statements
+}
+
+//nolint:cyclop
+func (s *splitter) split() {
+	for s.pos < len(s.sql) {
+		c := s.sql[s.pos]
+		switch {
+		case c == '\n':
+			s.line++
+			s.lineHasCode = false
+			s.current.WriteRune(' ')
+			s.pos++
+		case c == '-' && s.peek(1) == '-':
+			s.lineComment()
+		case c == '/' && s.peek(1) == '*':
+			s.blockComment()
+		case c == ';':
+			s.pos++
+			s.lineHasCode = true
+			s.end()
+		case c == '\'':
+			s.begin()
+			escapes := s.pos > 0 && (s.sql[s.pos-1] == 'e' || s.sql[s.pos-1] == 'E')
+			s.stringLiteral(escapes)
+			s.current.WriteString("''")
+		case c == '"':
+			s.begin()
+			s.quotedIdentifier()
+		case c == '$' && s.dollarTag() != "":
+			s.begin()
+			s.dollarQuoted(s.dollarTag())
+			s.current.WriteString("$$")
+		default:
+			if c != ' ' && c != '\t' && c != '\r' {
+				s.begin()
+			}
+			s.current.WriteRune(c)
+			s.pos++
+		}
+	}
+	s.end()
+}
+
+func (s *splitter) peek(offset int) rune {
+	if s.pos+offset >= len(s.sql) {
+		return 0
+	}
+
+	return s.sql[s.pos+offset]
+}
+
+// begin marks the start of a statement if none has been started yet.
+func (s *splitter) begin() {
+	s.lineHasCode = true
+	if s.currentLine == 0 {
+		s.currentLine = s.line
+	}
+}
+
+func (s *splitter) end() {
+	if s.currentLine == 0 {
+		s.current.Reset()
+
+		return
+	}
+
+	text := strings.TrimSpace(regexpWhitespace.ReplaceAllString(s.current.String(), " "))
+	s.statements = append(s.statements, &statement{
+		text:       text,
+		line:       s.currentLine,
+		endLine:    s.line,
+		suppressed: s.pending,
+	})
+	s.current.Reset()
+	s.currentLine = 0
+	s.pending = nil
+}
+
+func (s *splitter) lineComment() {
+	start := s.pos + 2
+	for s.pos < len(s.sql) && s.sql[s.pos] != '\n' {
+		s.pos++
+	}
+	s.comment(string(s.sql[start:s.pos]))
+}
+
+// blockComment skips a block comment, which may be nested in PostgreSQL.
+func (s *splitter) blockComment() {
+	start := s.pos + 2
+	depth := 0
+	for s.pos < len(s.sql) {
+		switch {
+		case s.sql[s.pos] == '/' && s.peek(1) == '*':
+			depth++
+			s.pos += 2
+		case s.sql[s.pos] == '*' && s.peek(1) == '/':
+			depth--
+			s.pos += 2
+		default:
+			if s.sql[s.pos] == '\n' {
+				s.line++
+			}
+			s.pos++
+		}
+		if depth == 0 {
+			s.comment(string(s.sql[start : s.pos-2]))
+			s.current.WriteRune(' ')
+
+			return
+		}
+	}
+}
+
+// comment attaches the suppression of a comment to its statement.
+func (s *splitter) comment(text string) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, SuppressionMarker) {
+		return
+	}
+
+	ids := strings.FieldsFunc(strings.TrimPrefix(text, SuppressionMarker), func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+	if len(ids) == 0 {
+		ids = []string{""}
+	}
+
+	switch {
+	case !s.lineHasCode, s.currentLine != 0:
+		s.pending = append(s.pending, ids...)
+	case len(s.statements) > 0:
+		// The comment follows the semicolon of the previous statement
+		last := s.statements[len(s.statements)-1]
+		last.suppressed = append(last.suppressed, ids...)
+	}
+}
+
+func (s *splitter) stringLiteral(escapes bool) {
+	s.pos++
+	for s.pos < len(s.sql) {
+		c := s.sql[s.pos]
+		switch {
+		case c == '\\' && escapes:
+			s.pos++
+		case c == '\'' && s.peek(1) == '\'':
+			s.pos++
+		case c == '\'':
+			s.pos++
+
+			return
+		case c == '\n':
+			s.line++
+		}
+		s.pos++
+	}
+}
+
+func (s *splitter) quotedIdentifier() {
+	s.pos++
+	for s.pos < len(s.sql) {
+		c := s.sql[s.pos]
+		if c == '"' {
+			if s.peek(1) != '"' {
+				s.pos++
+
+				return
+			}
+			s.pos++
+		}
+		s.current.WriteRune(c)
+		s.pos++
+	}
+}
+
+// dollarTag returns the tag of a dollar quote at the current position, e.g. $body$, or "" if there is none.
+func (s *splitter) dollarTag() string {
+	for end := s.pos + 1; end < len(s.sql); end++ {
+		c := s.sql[end]
+		if c == '$' {
+			return string(s.sql[s.pos : end+1])
+		}
+		isLetter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || c >= 0x80
+		if !isLetter && (end == s.pos+1 || c < '0' || c > '9') {
+			return ""
+		}
+	}
+
+	return ""
+}
+
+func (s *splitter) dollarQuoted(tag string) {
+	length := len([]rune(tag))
+	s.pos += length
+	for s.pos < len(s.sql) {
+		if s.sql[s.pos] == '$' && string(s.sql[s.pos:min(s.pos+length, len(s.sql))]) == tag {
+			s.pos += length
+
+			return
+		}
+		if s.sql[s.pos] == '\n' {
+			s.line++
+		}
+		s.pos++
+	}
+}
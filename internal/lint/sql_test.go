@@ -0,0 +1,162 @@
+package lint_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/printeers/trek/internal/lint"
+)
+
+func TestSplitStatements(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		sql  string
+		want []lint.Statement
+	}{
+		{
+			name: "empty",
+			sql:  "",
+			want: nil,
+		},
+		{
+			name: "only comments",
+			sql:  "-- comment\n/* block */\n",
+			want: nil,
+		},
+		{
+			name: "statements and lines",
+			sql:  "CREATE TABLE a (id int);\n\nALTER TABLE a\n  ADD COLUMN b text;\n",
+			want: []lint.Statement{
+				{Text: "CREATE TABLE a (id int)", Line: 1},
+				{Text: "ALTER TABLE a ADD COLUMN b text", Line: 3},
+			},
+		},
+		{
+			name: "statements on the same line",
+			sql:  "SELECT 1; SELECT 2;",
+			want: []lint.Statement{
+				{Text: "SELECT 1", Line: 1},
+				{Text: "SELECT 2", Line: 1},
+			},
+		},
+		{
+			name: "last statement without semicolon",
+			sql:  "SELECT 1;\nSELECT 2\n",
+			want: []lint.Statement{
+				{Text: "SELECT 1", Line: 1},
+				{Text: "SELECT 2", Line: 2},
+			},
+		},
+		{
+			name: "semicolons in literals and comments",
+			sql:  "INSERT INTO a VALUES ('x;y', E'\\';'); -- a;b\n/* c; /* nested; */ d; */ SELECT 1;\n",
+			want: []lint.Statement{
+				{Text: "INSERT INTO a VALUES ('', E'')", Line: 1},
+				{Text: "SELECT 1", Line: 2},
+			},
+		},
+		{
+			name: "quoted identifiers",
+			sql:  "ALTER TABLE \"public\".\"a;b\" DROP COLUMN \"say \"\"hi\"\"\";",
+			want: []lint.Statement{
+				{Text: "ALTER TABLE public.a;b DROP COLUMN say \"hi\"", Line: 1},
+			},
+		},
+		{
+			name: "dollar quotes",
+			sql: "CREATE FUNCTION f() RETURNS int AS $body$\nSELECT 1;\n$body$ LANGUAGE sql;\n" +
+				"DO $$ BEGIN PERFORM 1; END $$;\n",
+			want: []lint.Statement{
+				{Text: "CREATE FUNCTION f() RETURNS int AS $$ LANGUAGE sql", Line: 1},
+				{Text: "DO $$", Line: 4},
+			},
+		},
+		{
+			name: "positional parameters are no dollar quotes",
+			sql:  "PREPARE p AS SELECT $1; SELECT 2;",
+			want: []lint.Statement{
+				{Text: "PREPARE p AS SELECT $1", Line: 1},
+				{Text: "SELECT 2", Line: 1},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got := lint.SplitStatements(tt.sql)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SplitStatements(%q)\ngot  %+v\nwant %+v", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitStatementsSuppressions(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		sql  string
+		want [][]string
+	}{
+		{
+			name: "none",
+			sql:  "SELECT 1;\n-- just a comment\nSELECT 2;\n",
+			want: [][]string{nil, nil},
+		},
+		{
+			name: "line above applies to the next statement",
+			sql:  "SELECT 1;\n-- trek:lint-ignore drop-column\nSELECT 2;\n",
+			want: [][]string{nil, {"drop-column"}},
+		},
+		{
+			name: "end of line applies to the statement on the line",
+			sql:  "SELECT 1; -- trek:lint-ignore vacuum-full\nSELECT 2;\n",
+			want: [][]string{{"vacuum-full"}, nil},
+		},
+		{
+			name: "inside a statement applies to that statement",
+			sql:  "ALTER TABLE a -- trek:lint-ignore drop-column\n  DROP COLUMN b;\nSELECT 2;\n",
+			want: [][]string{{"drop-column"}, nil},
+		},
+		{
+			name: "without rule IDs suppresses all rules",
+			sql:  "-- trek:lint-ignore\nSELECT 1;\n",
+			want: [][]string{{""}},
+		},
+		{
+			name: "separated by commas and whitespace",
+			sql:  "-- trek:lint-ignore drop-column, set-not-null vacuum-full\nSELECT 1;\n",
+			want: [][]string{{"drop-column", "set-not-null", "vacuum-full"}},
+		},
+		{
+			name: "block comment",
+			sql:  "/* trek:lint-ignore set-not-null */\nSELECT 1;\n",
+			want: [][]string{{"set-not-null"}},
+		},
+		{
+			name: "several comments add up",
+			sql:  "-- trek:lint-ignore drop-column\n-- trek:lint-ignore set-not-null\nSELECT 1;\n",
+			want: [][]string{{"drop-column", "set-not-null"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			statements := lint.SplitStatements(tt.sql)
+			got := make([][]string, 0, len(statements))
+			for _, stmt := range statements {
+				got = append(got, stmt.Suppressed)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("suppressions of %q\ngot  %q\nwant %q", tt.sql, got, tt.want)
+			}
+		})
+	}
+}
@@ -1,20 +1,30 @@
 package internal
 
 import (
+	"errors"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
+	"strings"
 
 	"github.com/manifoldco/promptui"
 )
 
-const regexpPartialLowerKebabCase = `[a-z][a-z0-9\-]*[a-z]`
+const (
+	regexpPartialLowerKebabCase = `[a-z][a-z0-9\-]*[a-z]`
+
+	upMigrationSuffix   = ".up.sql"
+	downMigrationSuffix = ".down.sql"
+)
+
+var ErrMissingDownMigration = errors.New("missing down migration")
 
 var (
 	RegexpMigrationName     = regexp.MustCompile(`^` + regexpPartialLowerKebabCase + `$`)
-	RegexpMigrationFileName = regexp.MustCompile(`^\d{3}_` + regexpPartialLowerKebabCase + `\.up\.sql$`)
+	RegexpMigrationFileName = regexp.MustCompile(`^\d+_` + regexpPartialLowerKebabCase + `\.(up|down)\.sql$`)
 )
 
 func GetMigrationsDir(wd string) (string, error) {
@@ -29,13 +39,21 @@ func GetMigrationsDir(wd string) (string, error) {
 	return migrationsDir, nil
 }
 
-func GetMigrationFileName(migrationNumber uint, migrationName string) string {
-	return fmt.Sprintf("%03d_%s.up.sql", migrationNumber, migrationName)
+// GetDownMigrationFileName returns the name of the down migration belonging to
+// the given up migration. It works on plain file names as well as on paths.
+func GetDownMigrationFileName(upMigrationFileName string) string {
+	return strings.TrimSuffix(upMigrationFileName, upMigrationSuffix) + downMigrationSuffix
 }
 
+// GetNewMigrationFilePath returns the path and version of a new migration
+// after the given sorted up migrations. If the latest migration has the same
+// name, it is overwritten instead if overwrite is set or the user confirms it.
+// The concurrent migration of a split migration is overwritten together with
+// the migration before it, so that one is compared with the name.
 func GetNewMigrationFilePath(
 	migrationsDir string,
-	migrationsCount uint,
+	scheme VersionScheme,
+	migrationFiles []string,
 	migrationName string,
 	overwrite bool,
 ) (
@@ -43,13 +61,33 @@ func GetNewMigrationFilePath(
 	migrationNumber uint,
 	err error,
 ) {
-	var migrationsNumber uint
-	if _, err = os.Stat(
-		filepath.Join(migrationsDir, GetMigrationFileName(migrationsCount, migrationName)),
-	); err == nil {
-		if overwrite {
-			migrationsNumber = migrationsCount
-		} else {
+	if len(migrationFiles) > 0 {
+		latestFile := migrationFiles[len(migrationFiles)-1]
+
+		// A split migration ends with its concurrent migration, generate wrote both for the one before it
+		if len(migrationFiles) > 1 {
+			previousFile := migrationFiles[len(migrationFiles)-2]
+			var concurrentFile string
+			concurrentFile, err = scheme.ConcurrentMigrationFileName(previousFile)
+			if err != nil {
+				return "", 0, err
+			}
+			if concurrentFile == latestFile {
+				latestFile = previousFile
+			}
+		}
+
+		var latestVersion uint
+		latestVersion, err = GetMigrationVersion(latestFile)
+		if err != nil {
+			return "", 0, err
+		}
+
+		if latestFile == scheme.MigrationFileName(latestVersion, migrationName) {
+			if overwrite {
+				return filepath.Join(migrationsDir, latestFile), latestVersion, nil
+			}
+
 			prompt := promptui.Prompt{
 				//nolint:lll
 				Label:     "The previous migration has the same name. Overwrite the previous migration instead of creating a new one",
@@ -57,20 +95,28 @@ func GetNewMigrationFilePath(
 				Default:   "y",
 			}
 			if _, err = prompt.Run(); err == nil {
-				migrationsNumber = migrationsCount
-			} else {
-				migrationsNumber = migrationsCount + 1
+				return filepath.Join(migrationsDir, latestFile), latestVersion, nil
 			}
 		}
-	} else {
-		migrationsNumber = migrationsCount + 1
 	}
 
-	return filepath.Join(migrationsDir, GetMigrationFileName(migrationsNumber, migrationName)), migrationsNumber, nil
+	latestVersion, err := LatestMigrationVersion(migrationFiles)
+	if err != nil {
+		return "", 0, err
+	}
+	migrationNumber = scheme.NewVersion(latestVersion)
+
+	return filepath.Join(migrationsDir, scheme.MigrationFileName(migrationNumber, migrationName)), migrationNumber, nil
 }
 
+// FindMigrations returns the names of all up migrations in migrationsDir,
+// sorted by version. Down migrations are validated but not returned, use
+// GetDownMigrationFileName to find the down migration of an up migration.
+//
+//nolint:cyclop
 func FindMigrations(migrationsDir string, strict bool) ([]string, error) {
 	var files []string
+	var downFiles []string
 
 	err := filepath.WalkDir(migrationsDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
@@ -91,11 +137,184 @@ func FindMigrations(migrationsDir string, strict bool) ([]string, error) {
 			}
 		}
 
+		if strings.HasSuffix(d.Name(), downMigrationSuffix) {
+			downFiles = append(downFiles, d.Name())
+
+			return nil
+		}
+
 		files = append(files, d.Name())
 
 		return nil
 	})
+	if err != nil {
+		//nolint:wrapcheck
+		return nil, err
+	}
+
+	if strict {
+		for _, downFile := range downFiles {
+			upFile := strings.TrimSuffix(downFile, downMigrationSuffix) + upMigrationSuffix
+			if _, err = os.Stat(filepath.Join(migrationsDir, upFile)); err != nil {
+				//nolint:err113
+				return nil, fmt.Errorf("down migration %q has no matching up migration", downFile)
+			}
+		}
+	}
+
+	sortMigrationFiles(files)
+
+	return files, nil
+}
+
+// ConcurrentMigrationFileName returns the name of the migration that
+// follows the given up migration and holds its concurrent index operations.
+// It works on plain file names as well as on paths.
+func (s VersionScheme) ConcurrentMigrationFileName(upMigrationFileName string) (string, error) {
+	dir, base := filepath.Split(upMigrationFileName)
+
+	version, err := GetMigrationVersion(base)
+	if err != nil {
+		return "", err
+	}
+
+	_, name, _ := strings.Cut(strings.TrimSuffix(base, upMigrationSuffix), "_")
+
+	return dir + s.MigrationFileName(s.NextVersion(version), name+"-concurrently"), nil
+}
+
+// HasDownMigration reports whether the given up migration has a down migration.
+func HasDownMigration(migrationsDir, upMigrationFileName string) (bool, error) {
+	_, err := os.Stat(filepath.Join(migrationsDir, GetDownMigrationFileName(upMigrationFileName)))
+	if err == nil {
+		return true, nil
+	}
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+
+	return false, fmt.Errorf("failed to stat down migration: %w", err)
+}
+
+// GetMigrationVersion returns the version encoded in the prefix of a migration file name.
+func GetMigrationVersion(migrationFileName string) (uint, error) {
+	version, err := strconv.ParseUint(strings.Split(migrationFileName, "_")[0], 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse migration version of file %q: %w", migrationFileName, err)
+	}
+
+	return uint(version), nil
+}
+
+// MigrationStep is a single migration file that runs while migrating between two versions.
+type MigrationStep struct {
+	File    string
+	Version uint
+	Up      bool
+}
+
+// PlanMigrationSteps returns the migration files, in order of execution, that run when migrating a database from
+// version from to version to. The migrationFiles must be the sorted up migrations returned by FindMigrations.
+// Going down requires a down migration for every step, otherwise ErrMissingDownMigration is returned. Like
+// golang-migrate, it returns ErrUnknownVersion if there is no migration for version from, e.g. because the
+// database has been migrated with newer migrations.
+func PlanMigrationSteps(migrationsDir string, migrationFiles []string, from, to uint) ([]MigrationStep, error) {
+	if from != 0 {
+		_, err := MigrationsUpTo(migrationFiles, from)
+		if err != nil {
+			return nil, fmt.Errorf("database is at version %d: %w", from, err)
+		}
+	}
+
+	var steps []MigrationStep
+
+	if from <= to {
+		for _, file := range migrationFiles {
+			version, err := GetMigrationVersion(file)
+			if err != nil {
+				return nil, err
+			}
+			if version > from && version <= to {
+				steps = append(steps, MigrationStep{File: file, Version: version, Up: true})
+			}
+		}
+
+		return steps, nil
+	}
+
+	var missing []string
+	for i := len(migrationFiles) - 1; i >= 0; i-- {
+		file := migrationFiles[i]
+		version, err := GetMigrationVersion(file)
+		if err != nil {
+			return nil, err
+		}
+		if version <= to || version > from {
+			continue
+		}
+
+		hasDown, err := HasDownMigration(migrationsDir, file)
+		if err != nil {
+			return nil, err
+		}
+		if !hasDown {
+			missing = append(missing, GetDownMigrationFileName(file))
+
+			continue
+		}
+
+		steps = append(steps, MigrationStep{File: GetDownMigrationFileName(file), Version: version, Up: false})
+	}
+
+	if len(missing) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrMissingDownMigration, strings.Join(missing, ", "))
+	}
+
+	return steps, nil
+}
+
+// RenumberMigration renames the up migration, its down migration and the
+// given testdata files to the new version. Use FindTestdata to find the
+// testdata of the migration. It returns the new name of the up migration.
+func RenumberMigration(
+	scheme VersionScheme,
+	migrationsDir,
+	upMigrationFileName string,
+	testdataFiles []string,
+	newVersion uint,
+) (string, error) {
+	_, name, _ := strings.Cut(strings.TrimSuffix(upMigrationFileName, upMigrationSuffix), "_")
+	newUpMigrationFileName := scheme.MigrationFileName(newVersion, name)
+
+	renames := map[string]string{
+		filepath.Join(migrationsDir, upMigrationFileName): filepath.Join(migrationsDir, newUpMigrationFileName),
+	}
+
+	hasDown, err := HasDownMigration(migrationsDir, upMigrationFileName)
+	if err != nil {
+		return "", err
+	}
+	if hasDown {
+		renames[filepath.Join(migrationsDir, GetDownMigrationFileName(upMigrationFileName))] =
+			filepath.Join(migrationsDir, GetDownMigrationFileName(newUpMigrationFileName))
+	}
+
+	for _, file := range testdataFiles {
+		dir, base := filepath.Split(file)
+		renames[file] = filepath.Join(dir, scheme.FormatVersion(newVersion)+strings.TrimLeft(base, "0123456789"))
+	}
+
+	for oldPath, newPath := range renames {
+		if _, err = os.Stat(newPath); err == nil {
+			//nolint:err113
+			return "", fmt.Errorf("can't rename %q, %q already exists", oldPath, newPath)
+		}
+
+		err = os.Rename(oldPath, newPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to rename %q: %w", oldPath, err)
+		}
+	}
 
-	//nolint:wrapcheck
-	return files, err
+	return newUpMigrationFileName, nil
 }
@@ -0,0 +1,386 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+
+	"github.com/printeers/trek/internal/configuration"
+)
+
+// NoTransactionMarker is the first line of migrations that must run outside a transaction, such as migrations with
+// concurrent index operations. Their statements are executed one by one instead of as a single implicit transaction.
+const NoTransactionMarker = "-- trek:no-transaction"
+
+var ErrSquashedVersion = errors.New("database version has been squashed")
+
+// Migrator runs migration files with golang-migrate. Migrations marked with
+// NoTransactionMarker are run through a second instance that has
+// golang-migrate's multi statement mode enabled.
+//
+// The versions stored in the database are the versions of the files plus
+// versionOffset, see configuration.Config.VersionOffset. All versions passed
+// to and returned by the Migrator are versions of files.
+type Migrator struct {
+	migrationsDir   string
+	versionOffset   uint
+	transaction     *migrate.Migrate
+	noTransaction   *migrate.Migrate
+	noTransactionDB string
+	dsn             string
+	runHook         func(hookName string, step MigrationStep) error
+}
+
+func NewMigrator(migrationsDir, dsn string, versionOffset uint) (*Migrator, error) {
+	m, err := newMigrate(migrationsDir, dsn, versionOffset)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse dsn: %w", err)
+	}
+	query := u.Query()
+	query.Set("x-multi-statement", "true")
+	u.RawQuery = query.Encode()
+
+	return &Migrator{
+		migrationsDir:   migrationsDir,
+		versionOffset:   versionOffset,
+		transaction:     m,
+		noTransactionDB: u.String(),
+		dsn:             dsn,
+	}, nil
+}
+
+// EnableHooks makes the Migrator run the migration-pre and migration-post
+// hooks around every step. The hooks get the connection details of the
+// database and the file, version and direction of the step. Nothing changes
+// if neither hook exists.
+func (m *Migrator) EnableHooks(ctx context.Context, config *configuration.Config, wd string) {
+	if !HookExists(wd, "migration-pre") && !HookExists(wd, "migration-post") {
+		return
+	}
+
+	m.runHook = func(hookName string, step MigrationStep) error {
+		return RunHook(ctx, config, wd, hookName, &HookOptions{DSN: m.dsn, Migration: &step})
+	}
+}
+
+// Close closes the connections of both golang-migrate instances.
+func (m *Migrator) Close() error {
+	var errs []error
+	for _, instance := range []*migrate.Migrate{m.transaction, m.noTransaction} {
+		if instance == nil {
+			continue
+		}
+		sourceErr, databaseErr := instance.Close()
+		errs = append(errs, sourceErr, databaseErr)
+	}
+
+	//nolint:wrapcheck
+	return errors.Join(errs...)
+}
+
+// Version returns the current version of the database, 0 if no migration has been applied yet.
+func (m *Migrator) Version() (version uint, dirty bool, err error) {
+	version, dirty, err = m.transaction.Version()
+	if errors.Is(err, migrate.ErrNilVersion) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to get database version: %w", err)
+	}
+
+	version, err = FileVersion(version, m.versionOffset)
+	if err != nil {
+		return 0, false, err
+	}
+
+	return version, dirty, nil
+}
+
+// FileVersion returns the version of the migration file that corresponds to
+// the version stored in the database. Databases that are still at a version
+// that has been squashed can't be migrated any more.
+func FileVersion(databaseVersion, versionOffset uint) (uint, error) {
+	if databaseVersion == 0 {
+		return 0, nil
+	}
+	if databaseVersion <= versionOffset {
+		return 0, fmt.Errorf(
+			"%w: database is at version %d, apply the migrations up to version %d with the migrations from before the squash",
+			ErrSquashedVersion,
+			databaseVersion,
+			versionOffset+1,
+		)
+	}
+
+	return databaseVersion - versionOffset, nil
+}
+
+// Step runs a single migration step. The step must be the next one from the current version of the database.
+func (m *Migrator) Step(step MigrationStep) error {
+	instance, err := m.instance(step.File)
+	if err != nil {
+		return err
+	}
+
+	n := 1
+	if !step.Up {
+		n = -1
+	}
+
+	if m.runHook != nil {
+		err = m.runHook("migration-pre", step)
+		if err != nil {
+			return fmt.Errorf("failed to run hook: %w", err)
+		}
+	}
+
+	err = instance.Steps(n)
+	if err != nil {
+		//nolint:wrapcheck
+		return err
+	}
+
+	if m.runHook != nil {
+		err = m.runHook("migration-post", step)
+		if err != nil {
+			return fmt.Errorf("failed to run hook: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// Up runs all pending migrations. It returns migrate.ErrNoChange if there are none.
+func (m *Migrator) Up(migrationFiles []string) error {
+	if len(migrationFiles) == 0 {
+		return migrate.ErrNoChange
+	}
+
+	latestVersion, err := GetMigrationVersion(migrationFiles[len(migrationFiles)-1])
+	if err != nil {
+		return err
+	}
+
+	return m.Migrate(migrationFiles, latestVersion)
+}
+
+// Force sets the version of the database without running any migration, like
+// golang-migrate's force command. The database is no longer dirty afterwards.
+func (m *Migrator) Force(version uint) error {
+	return m.transaction.Force(int(version + m.versionOffset)) //nolint:gosec,wrapcheck
+}
+
+// Migrate runs all migrations up or down to the given version with
+// golang-migrate's Migrate. Consecutive migrations that run in the same
+// transaction mode are passed to golang-migrate at once. If hooks are enabled,
+// the migrations run one by one, so that the hooks run around each of them.
+// It returns migrate.ErrNoChange if there are none.
+func (m *Migrator) Migrate(migrationFiles []string, version uint) error {
+	currentVersion, dirty, err := m.Version()
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return migrate.ErrDirty{Version: int(currentVersion)} //nolint:gosec
+	}
+
+	steps, err := PlanMigrationSteps(m.migrationsDir, migrationFiles, currentVersion, version)
+	if err != nil {
+		return err
+	}
+	if len(steps) == 0 {
+		return migrate.ErrNoChange
+	}
+
+	if m.runHook != nil {
+		for _, step := range steps {
+			err = m.Step(step)
+			if err != nil {
+				return fmt.Errorf("failed to run migration %q: %w", step.File, err)
+			}
+		}
+
+		return nil
+	}
+
+	return m.migrateRuns(steps, version)
+}
+
+// migrateRuns passes each run of consecutive steps that use the same
+// golang-migrate instance to its Migrate.
+func (m *Migrator) migrateRuns(steps []MigrationStep, version uint) error {
+	for start := 0; start < len(steps); {
+		instance, err := m.instance(steps[start].File)
+		if err != nil {
+			return err
+		}
+
+		end := start + 1
+		for ; end < len(steps); end++ {
+			var next *migrate.Migrate
+			next, err = m.instance(steps[end].File)
+			if err != nil {
+				return err
+			}
+			if next != instance {
+				break
+			}
+		}
+
+		// A run of down migrations ends at the version before its last one, which is the one the next run reverts
+		runVersion := steps[end-1].Version
+		if !steps[end-1].Up {
+			runVersion = version
+			if end < len(steps) {
+				runVersion = steps[end].Version
+			}
+		}
+
+		if runVersion == 0 {
+			err = instance.Down()
+		} else {
+			err = instance.Migrate(runVersion + m.versionOffset)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to migrate to version %d: %w", runVersion, err)
+		}
+
+		start = end
+	}
+
+	return nil
+}
+
+func (m *Migrator) instance(file string) (*migrate.Migrate, error) {
+	noTransaction, err := IsNoTransactionMigration(filepath.Join(m.migrationsDir, file))
+	if err != nil {
+		return nil, err
+	}
+	if !noTransaction {
+		return m.transaction, nil
+	}
+
+	if m.noTransaction == nil {
+		m.noTransaction, err = newMigrate(m.migrationsDir, m.noTransactionDB, m.versionOffset)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return m.noTransaction, nil
+}
+
+func newMigrate(migrationsDir, dsn string, versionOffset uint) (*migrate.Migrate, error) {
+	sourceURL := fmt.Sprintf("file://%s", migrationsDir)
+	if versionOffset == 0 {
+		m, err := migrate.New(sourceURL, dsn)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize go-migrate: %w", err)
+		}
+
+		return m, nil
+	}
+
+	sourceDriver, err := source.Open(sourceURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open migrations: %w", err)
+	}
+
+	m, err := migrate.NewWithSourceInstance("file", &offsetSource{Driver: sourceDriver, offset: versionOffset}, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize go-migrate: %w", err)
+	}
+
+	return m, nil
+}
+
+// offsetSource adds an offset to the versions of the migration files, so that
+// golang-migrate stores the versions the database had before a squash.
+type offsetSource struct {
+	source.Driver
+	offset uint
+}
+
+func (s *offsetSource) First() (uint, error) {
+	version, err := s.Driver.First()
+	if err != nil {
+		return 0, err //nolint:wrapcheck
+	}
+
+	return version + s.offset, nil
+}
+
+func (s *offsetSource) Prev(version uint) (uint, error) {
+	if version <= s.offset {
+		return 0, s.notExist("prev for version", version)
+	}
+
+	prevVersion, err := s.Driver.Prev(version - s.offset)
+	if err != nil {
+		return 0, err //nolint:wrapcheck
+	}
+
+	return prevVersion + s.offset, nil
+}
+
+func (s *offsetSource) Next(version uint) (uint, error) {
+	if version <= s.offset {
+		return 0, s.notExist("next for version", version)
+	}
+
+	nextVersion, err := s.Driver.Next(version - s.offset)
+	if err != nil {
+		return 0, err //nolint:wrapcheck
+	}
+
+	return nextVersion + s.offset, nil
+}
+
+func (s *offsetSource) ReadUp(version uint) (io.ReadCloser, string, error) {
+	if version <= s.offset {
+		return nil, "", s.notExist("read up for version", version)
+	}
+
+	//nolint:wrapcheck
+	return s.Driver.ReadUp(version - s.offset)
+}
+
+func (s *offsetSource) ReadDown(version uint) (io.ReadCloser, string, error) {
+	if version <= s.offset {
+		return nil, "", s.notExist("read down for version", version)
+	}
+
+	//nolint:wrapcheck
+	return s.Driver.ReadDown(version - s.offset)
+}
+
+// notExist returns the error golang-migrate expects for versions without migration.
+func (s *offsetSource) notExist(op string, version uint) error {
+	return &fs.PathError{Op: op, Path: strconv.FormatUint(uint64(version), 10), Err: fs.ErrNotExist}
+}
+
+// IsNoTransactionMigration reports whether the migration file starts with NoTransactionMarker.
+func IsNoTransactionMigration(path string) (bool, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to read migration file: %w", err)
+	}
+
+	return strings.HasPrefix(string(content), NoTransactionMarker), nil
+}
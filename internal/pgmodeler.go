@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
 )
 
 func PgmodelerExportSQL(ctx context.Context, input, output string) error {
@@ -86,3 +89,40 @@ func PgmodelerExportSVG(ctx context.Context, input, output string) error {
 
 	return nil
 }
+
+// PgmodelerImportDB creates a model of the database of the DSN with pgModeler's database import.
+func PgmodelerImportDB(ctx context.Context, dsn, output string) error {
+	connConfig, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return fmt.Errorf("failed to parse dsn: %w", err)
+	}
+
+	//nolint:gosec
+	cmdPgModeler := exec.CommandContext(
+		ctx,
+		"pgmodeler-cli",
+		"--import-db",
+		"--input-db",
+		connConfig.Database,
+		"--host",
+		connConfig.Host,
+		"--port",
+		strconv.Itoa(int(connConfig.Port)),
+		"--user",
+		connConfig.User,
+		"--initial-db",
+		connConfig.Database,
+		"--output",
+		output,
+	)
+	// Arguments can be read by every user of the machine, so the password is passed to libpq in the environment
+	cmdPgModeler.Env = append(os.Environ(), "PGPASSWORD="+connConfig.Password)
+	cmdPgModeler.Stderr = os.Stderr
+
+	out, err := cmdPgModeler.Output()
+	if err != nil {
+		return fmt.Errorf("failed to run pgmodeler: %w %s", err, string(out))
+	}
+
+	return nil
+}
@@ -2,17 +2,34 @@ package postgres
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
+// codeDuplicateObject is the SQLSTATE of creating a role that already exists.
+const codeDuplicateObject = "42710"
+
+// Instance is a PostgreSQL server trek uses to generate and check migrations.
+// Databases are referred to by name, the instance maps them to the databases
+// it actually created. The name postgres refers to the maintenance database.
 type Instance interface {
+	// Start starts the instance on the given port. A free port is picked if the port is 0.
 	Start(port uint32) error
+	// Stop drops everything that has been created in the instance and stops it.
 	Stop() error
 	DSN(database string) string
+	// CreateDatabase creates an empty database.
+	CreateDatabase(ctx context.Context, database string) error
+	// CreateRole creates a role without LOGIN under its own name, because the migrations refer to roles by name.
+	// Creating a role that has already been created by the instance does nothing.
+	CreateRole(ctx context.Context, role string) error
+	// Reset drops all databases and roles that have been created, so that the instance can be reused.
+	Reset(ctx context.Context) error
 }
 
 func NewPostgresInstance() Instance {
@@ -21,6 +38,20 @@ func NewPostgresInstance() Instance {
 	return db
 }
 
+// createRoleSQL returns the statement all instances create roles with. Roles
+// only own objects and get privileges in the migrations, nobody logs in with
+// them. Hooks and testdata use SET ROLE to act as a role.
+func createRoleSQL(role string) string {
+	return fmt.Sprintf("CREATE ROLE %q NOLOGIN;", role)
+}
+
+// isDuplicateObject reports whether the error is caused by creating an object that already exists.
+func isDuplicateObject(err error) bool {
+	var pgErr *pgconn.PgError
+
+	return errors.As(err, &pgErr) && pgErr.Code == codeDuplicateObject
+}
+
 func PgDump(ctx context.Context, dsn string, args []string) (string, error) {
 	cmd := []string{
 		"pg_dump",
@@ -41,28 +72,6 @@ func PgDump(ctx context.Context, dsn string, args []string) (string, error) {
 	return string(stdout), nil
 }
 
-func PsqlFile(ctx context.Context, dsn, file string) error {
-	cmdPsql := exec.CommandContext(
-		ctx,
-		"psql",
-		"--echo-errors",
-		"--variable",
-		"ON_ERROR_STOP=1",
-		"--dbname",
-		dsn,
-		"--file",
-		file,
-	)
-	cmdPsql.Stderr = os.Stderr
-
-	err := cmdPsql.Run()
-	if err != nil {
-		return fmt.Errorf("failed to run psql: %w", err)
-	}
-
-	return nil
-}
-
 func CheckDatabaseExists(ctx context.Context, conn *pgx.Conn, database string) (bool, error) {
 	row := conn.QueryRow(
 		ctx,
@@ -108,6 +117,41 @@ func CheckTableExists(ctx context.Context, conn *pgx.Conn, schema, name string)
 	return exists, nil
 }
 
+// GetSchemaMigrationsVersion reads the version golang-migrate stored in the schema_migrations table. The returned
+// found is false if the table does not exist or holds no version yet.
+func GetSchemaMigrationsVersion(ctx context.Context, conn *pgx.Conn) (version uint, dirty, found bool, err error) {
+	exists, err := CheckTableExists(ctx, conn, "public", "schema_migrations")
+	if err != nil {
+		return 0, false, false, err
+	}
+	if !exists {
+		return 0, false, false, nil
+	}
+
+	var v int64
+	err = conn.QueryRow(ctx, "SELECT version, dirty FROM public.schema_migrations LIMIT 1;").Scan(&v, &dirty)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, false, false, nil
+	}
+	if err != nil {
+		return 0, false, false, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	if v < 0 {
+		return 0, dirty, false, nil
+	}
+
+	return uint(v), dirty, true, nil
+}
+
+// GetRoles returns the names of the roles of the server, except the built-in pg_* roles and the connected user.
+func GetRoles(ctx context.Context, conn *pgx.Conn) ([]string, error) {
+	return queryNames(
+		ctx,
+		conn,
+		"SELECT rolname FROM pg_roles WHERE rolname NOT LIKE 'pg\\_%' AND rolname <> current_user ORDER BY rolname;",
+	)
+}
+
 func DSN(conn *pgx.Conn, sslmode string) string {
 	config := conn.Config()
 
@@ -121,3 +165,13 @@ func DSN(conn *pgx.Conn, sslmode string) string {
 		sslmode,
 	)
 }
+
+func queryNames(ctx context.Context, conn *pgx.Conn, query string) ([]string, error) {
+	rows, err := conn.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query: %w", err)
+	}
+
+	//nolint:wrapcheck
+	return pgx.CollectRows(rows, pgx.RowTo[string])
+}
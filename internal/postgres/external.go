@@ -0,0 +1,152 @@
+package postgres
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+
+	"github.com/jackc/pgx/v5"
+)
+
+var _ Instance = &postgresInstanceExternal{}
+
+var (
+	errInvalidDSN = errors.New("invalid dsn")
+	errRoleExists = errors.New("role already exists on the server")
+)
+
+// postgresInstanceExternal uses an existing server. Databases are created with
+// a unique prefix, so that several runs can share the server. Roles are not
+// renamed: role names are global to the server and the migrations, the model
+// and the generated statements refer to them by name. To never touch roles of
+// the server or of another run, creating a role that already exists fails.
+// Roles are dropped again by Reset.
+type postgresInstanceExternal struct {
+	dsn       *url.URL
+	prefix    string
+	databases []string
+	roles     []string
+}
+
+// NewExternalPostgresInstance returns an instance that uses the server of the
+// given DSN. The database of the DSN is used as maintenance database, the user
+// must be allowed to create databases and roles.
+func NewExternalPostgresInstance(dsn string) (Instance, error) {
+	u, err := url.Parse(dsn)
+	if err != nil || (u.Scheme != "postgres" && u.Scheme != "postgresql") {
+		return nil, fmt.Errorf("%w: must be a postgres:// url", errInvalidDSN)
+	}
+
+	return &postgresInstanceExternal{dsn: u}, nil
+}
+
+// Start checks the connection to the server, the port is ignored.
+func (p *postgresInstanceExternal) Start(_ uint32) error {
+	suffix := make([]byte, 4)
+	_, err := rand.Read(suffix)
+	if err != nil {
+		return fmt.Errorf("failed to generate database prefix: %w", err)
+	}
+	p.prefix = fmt.Sprintf("trek_%s_", hex.EncodeToString(suffix))
+
+	ctx := context.Background()
+
+	conn, err := pgx.Connect(ctx, p.DSN("postgres"))
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+
+	//nolint:wrapcheck
+	return conn.Close(ctx)
+}
+
+func (p *postgresInstanceExternal) Stop() error {
+	return p.Reset(context.Background())
+}
+
+func (p *postgresInstanceExternal) DSN(database string) string {
+	if database == "postgres" {
+		return p.dsn.String()
+	}
+
+	u := *p.dsn
+	u.Path = "/" + p.databaseName(database)
+
+	return u.String()
+}
+
+func (p *postgresInstanceExternal) CreateDatabase(ctx context.Context, database string) error {
+	name := p.databaseName(database)
+
+	err := p.exec(ctx, fmt.Sprintf("CREATE DATABASE %q;", name))
+	if err != nil {
+		return err
+	}
+	p.databases = append(p.databases, name)
+
+	return nil
+}
+
+func (p *postgresInstanceExternal) CreateRole(ctx context.Context, role string) error {
+	if slices.Contains(p.roles, role) {
+		return nil
+	}
+
+	err := p.exec(ctx, createRoleSQL(role))
+	if isDuplicateObject(err) {
+		return fmt.Errorf("%w: %q, trek only uses roles it created itself, drop the role or use another server",
+			errRoleExists,
+			role,
+		)
+	}
+	if err != nil {
+		return err
+	}
+	p.roles = append(p.roles, role)
+
+	return nil
+}
+
+// Reset drops the databases and roles that have been created by this instance.
+func (p *postgresInstanceExternal) Reset(ctx context.Context) error {
+	for _, database := range p.databases {
+		err := p.exec(ctx, fmt.Sprintf("DROP DATABASE IF EXISTS %q WITH (FORCE);", database))
+		if err != nil {
+			return err
+		}
+	}
+	p.databases = nil
+
+	for _, role := range p.roles {
+		err := p.exec(ctx, fmt.Sprintf("DROP ROLE IF EXISTS %q;", role))
+		if err != nil {
+			return err
+		}
+	}
+	p.roles = nil
+
+	return nil
+}
+
+func (p *postgresInstanceExternal) databaseName(database string) string {
+	return p.prefix + database
+}
+
+func (p *postgresInstanceExternal) exec(ctx context.Context, sql string) error {
+	conn, err := pgx.Connect(ctx, p.DSN("postgres"))
+	if err != nil {
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	_, err = conn.Exec(ctx, sql)
+	if err != nil {
+		return fmt.Errorf("failed to execute %q: %w", sql, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,276 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/printeers/trek/internal"
+	"github.com/printeers/trek/internal/psql"
+)
+
+// maxSnapshots is the number of snapshots kept per project. The least recently used ones are deleted.
+const maxSnapshots = 10
+
+// SnapshotCache stores dumps of databases after the first N migrations. A
+// snapshot is keyed by the content of those migrations, so it becomes invalid
+// as soon as one of them changes.
+type SnapshotCache struct {
+	dir           string
+	wd            string
+	migrationsDir string
+	roles         []string
+	versionOffset uint
+	testdata      bool
+}
+
+// NewSnapshotCache returns the cache of the project in the working directory.
+// If testdata is set, the snapshots include the testdata of the migrations.
+// The version offset is part of the key, because the dumps hold the version
+// stored in schema_migrations.
+func NewSnapshotCache(
+	wd,
+	migrationsDir string,
+	roles []string,
+	versionOffset uint,
+	testdata bool,
+) (*SnapshotCache, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cache directory: %w", err)
+	}
+
+	projectHash := sha256.Sum256([]byte(wd))
+	dir := filepath.Join(cacheDir, "trek", "snapshots", hex.EncodeToString(projectHash[:8]))
+
+	err = os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	roles = slices.Clone(roles)
+	sort.Strings(roles)
+
+	return &SnapshotCache{
+		dir:           dir,
+		wd:            wd,
+		migrationsDir: migrationsDir,
+		roles:         roles,
+		versionOffset: versionOffset,
+		testdata:      testdata,
+	}, nil
+}
+
+// Restore loads the newest snapshot that is valid for the migration files into
+// the empty database. It returns the number of migrations the snapshot holds,
+// 0 if there is no valid snapshot.
+func (c *SnapshotCache) Restore(ctx context.Context, dsn string, migrationFiles []string) (uint, error) {
+	keys, err := c.keys(ctx, dsn, migrationFiles)
+	if err != nil {
+		return 0, err
+	}
+
+	for n := len(keys); n > 0; n-- {
+		path := c.path(keys[n-1])
+		if _, err = os.Stat(path); err != nil {
+			continue
+		}
+
+		err = psql.RunFile(ctx, dsn, path, nil)
+		if err != nil {
+			return 0, fmt.Errorf("failed to restore snapshot: %w", err)
+		}
+
+		// Mark the snapshot as recently used
+		now := time.Now()
+		_ = os.Chtimes(path, now, now)
+
+		return uint(n), nil
+	}
+
+	return 0, nil
+}
+
+// Save stores a snapshot of the database, which must hold exactly the migration files.
+func (c *SnapshotCache) Save(ctx context.Context, dsn string, migrationFiles []string) error {
+	if len(migrationFiles) == 0 {
+		return nil
+	}
+
+	keys, err := c.keys(ctx, dsn, migrationFiles)
+	if err != nil {
+		return err
+	}
+
+	dump, err := PgDump(ctx, dsn, nil)
+	if err != nil {
+		return fmt.Errorf("failed to dump database: %w", err)
+	}
+
+	// Write to a temporary file first, so that a partial snapshot is never restored
+	tmpFile, err := os.CreateTemp(c.dir, "snapshot-")
+	if err != nil {
+		return fmt.Errorf("failed to create snapshot file: %w", err)
+	}
+	defer os.Remove(tmpFile.Name()) //nolint:errcheck
+
+	_, err = io.WriteString(tmpFile, dump)
+	if err != nil {
+		_ = tmpFile.Close()
+
+		return fmt.Errorf("failed to write snapshot file: %w", err)
+	}
+
+	err = tmpFile.Close()
+	if err != nil {
+		return fmt.Errorf("failed to close snapshot file: %w", err)
+	}
+
+	err = os.Rename(tmpFile.Name(), c.path(keys[len(keys)-1]))
+	if err != nil {
+		return fmt.Errorf("failed to move snapshot file: %w", err)
+	}
+
+	return c.prune()
+}
+
+func (c *SnapshotCache) path(key string) string {
+	return filepath.Join(c.dir, key+".sql")
+}
+
+// keys returns the key of the snapshot after each migration. Besides the
+// migrations, the key covers everything else that ends up in the snapshot,
+// including the version of the server the database is on.
+func (c *SnapshotCache) keys(ctx context.Context, dsn string, migrationFiles []string) ([]string, error) {
+	serverVersion, err := getServerVersion(ctx, dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	_, _ = fmt.Fprintf(h, "trek-snapshot\x00%s\x00%t\x00%q\x00%d\x00",
+		serverVersion,
+		c.testdata,
+		c.roles,
+		c.versionOffset,
+	)
+
+	keys := make([]string, 0, len(migrationFiles))
+	for _, file := range migrationFiles {
+		paths := []string{
+			filepath.Join(c.migrationsDir, file),
+			filepath.Join(c.migrationsDir, internal.GetDownMigrationFileName(file)),
+		}
+
+		if c.testdata {
+			var version uint
+			version, err = internal.GetMigrationVersion(file)
+			if err != nil {
+				return nil, err //nolint:wrapcheck
+			}
+
+			var testdataFiles []string
+			testdataFiles, err = internal.FindTestdata(c.wd, version)
+			if err != nil {
+				return nil, fmt.Errorf("failed to find testdata: %w", err)
+			}
+			paths = append(paths, testdataFiles...)
+		}
+
+		for _, path := range paths {
+			err = hashFile(h, path)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		keys = append(keys, hex.EncodeToString(h.Sum(nil)))
+	}
+
+	return keys, nil
+}
+
+func getServerVersion(ctx context.Context, dsn string) (string, error) {
+	conn, err := pgx.Connect(ctx, dsn)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to database: %w", err)
+	}
+	defer conn.Close(ctx)
+
+	var version string
+	err = conn.QueryRow(ctx, "SHOW server_version;").Scan(&version)
+	if err != nil {
+		return "", fmt.Errorf("failed to get server version: %w", err)
+	}
+
+	return version, nil
+}
+
+// hashFile writes the name and content of the file to the hash. Files that
+// don't exist are hashed as such.
+func hashFile(h hash.Hash, path string) error {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		_, _ = fmt.Fprintf(h, "%s\x00-1\x00", filepath.Base(path))
+
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %q: %w", path, err)
+	}
+
+	_, _ = fmt.Fprintf(h, "%s\x00%d\x00", filepath.Base(path), len(content))
+	_, _ = h.Write(content)
+
+	return nil
+}
+
+// prune deletes the least recently used snapshots above maxSnapshots.
+func (c *SnapshotCache) prune() error {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return fmt.Errorf("failed to read snapshot directory: %w", err)
+	}
+
+	type snapshot struct {
+		path    string
+		modTime time.Time
+	}
+
+	var snapshots []snapshot
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+		var info fs.FileInfo
+		info, err = entry.Info()
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, snapshot{path: filepath.Join(c.dir, entry.Name()), modTime: info.ModTime()})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].modTime.After(snapshots[j].modTime)
+	})
+
+	for index := maxSnapshots; index < len(snapshots); index++ {
+		err = os.Remove(snapshots[index].path)
+		if err != nil {
+			return fmt.Errorf("failed to delete snapshot: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,34 @@
+package psql
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// RunFile runs the SQL file with psql and stops at the first error. The output
+// of psql is written to stdout, or discarded if stdout is nil.
+func RunFile(ctx context.Context, dsn, file string, stdout io.Writer) error {
+	cmdPsql := exec.CommandContext(
+		ctx,
+		"psql",
+		"--echo-errors",
+		"--variable",
+		"ON_ERROR_STOP=1",
+		"--dbname",
+		dsn,
+		"--file",
+		file,
+	)
+	cmdPsql.Stdout = stdout
+	cmdPsql.Stderr = os.Stderr
+
+	err := cmdPsql.Run()
+	if err != nil {
+		return fmt.Errorf("failed to run psql: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,229 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// SumFileName is the name of the file in the working directory that holds the
+// hashes of the migration and testdata files.
+const SumFileName = "migrations.sum"
+
+var ErrSumMismatch = errors.New("migration files don't match " + SumFileName)
+
+// Sum maps the paths of migration and testdata files, relative to the working
+// directory and with forward slashes, to the hex encoded SHA-256 of their
+// content.
+type Sum map[string]string
+
+// ReadSum reads the sum file. It returns nil if the file doesn't exist.
+func ReadSum(wd string) (Sum, error) {
+	file, err := os.Open(filepath.Join(wd, SumFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", SumFileName, err)
+	}
+	defer file.Close() //nolint:errcheck
+
+	sum := Sum{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		hash, p, ok := strings.Cut(scanner.Text(), "  ")
+		if !ok {
+			//nolint:err113
+			return nil, fmt.Errorf("invalid line %d in %s", line, SumFileName)
+		}
+		sum[p] = hash
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", SumFileName, err)
+	}
+
+	return sum, nil
+}
+
+// WriteSum writes the sum file in the format of sha256sum, so that it can be
+// verified with "sha256sum -c migrations.sum".
+func WriteSum(wd string, sum Sum) error {
+	paths := make([]string, 0, len(sum))
+	for p := range sum {
+		paths = append(paths, p)
+	}
+	slices.Sort(paths)
+
+	var sb strings.Builder
+	for _, p := range paths {
+		sb.WriteString(fmt.Sprintf("%s  %s\n", sum[p], p))
+	}
+
+	//nolint:gosec
+	err := os.WriteFile(filepath.Join(wd, SumFileName), []byte(sb.String()), 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", SumFileName, err)
+	}
+
+	return nil
+}
+
+// HashFiles returns the hashes of all migration and testdata files, including
+// the testdata in subdirectories.
+func HashFiles(wd, migrationsDir string) (Sum, error) {
+	sum := Sum{}
+	testdataDir := filepath.Join(wd, "testdata")
+	for _, dir := range []string{migrationsDir, testdataDir} {
+		err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if file != dir && dir != testdataDir {
+					return filepath.SkipDir
+				}
+
+				return nil
+			}
+			if !strings.HasSuffix(d.Name(), ".sql") {
+				return nil
+			}
+
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", d.Name(), err)
+			}
+
+			rel, err := filepath.Rel(wd, file)
+			if err != nil {
+				return fmt.Errorf("failed to get relative path of %s: %w", d.Name(), err)
+			}
+
+			hash := sha256.Sum256(content)
+			sum[filepath.ToSlash(rel)] = hex.EncodeToString(hash[:])
+
+			return nil
+		})
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", dir, err)
+		}
+	}
+
+	return sum, nil
+}
+
+// UpdateSum rehashes the files of the migrations from the given version on
+// and keeps the entries of the earlier migrations as they are. Run by
+// generate, which only writes the latest migrations. If there is no sum file
+// yet, all files are hashed.
+func UpdateSum(wd, migrationsDir string, fromVersion uint) error {
+	sum, err := ReadSum(wd)
+	if err != nil {
+		return err
+	}
+
+	files, err := HashFiles(wd, migrationsDir)
+	if err != nil {
+		return err
+	}
+	if sum == nil {
+		return WriteSum(wd, files)
+	}
+
+	// New files of the latest hashed migration, such as testdata written after
+	// it was generated, are still in order and get hashed as well
+	latestVersion := sum.latestVersion()
+
+	updated := Sum{}
+	for p, hash := range sum {
+		if sumFileVersion(p) < fromVersion {
+			updated[p] = hash
+		}
+	}
+	for p, hash := range files {
+		_, hashed := sum[p]
+		if sumFileVersion(p) >= fromVersion || (!hashed && sumFileVersion(p) >= latestVersion) {
+			updated[p] = hash
+		}
+	}
+
+	return WriteSum(wd, updated)
+}
+
+// VerifySum compares the files with the sum file and returns a problem for
+// every file that has been changed or deleted since it was hashed, and for
+// every new file that belongs to a migration before the latest hashed one.
+// It returns ok false if there is no sum file.
+func VerifySum(wd, migrationsDir string) (problems []string, ok bool, err error) {
+	sum, err := ReadSum(wd)
+	if err != nil {
+		return nil, false, err
+	}
+	if sum == nil {
+		return nil, false, nil
+	}
+
+	files, err := HashFiles(wd, migrationsDir)
+	if err != nil {
+		return nil, false, err
+	}
+
+	latestVersion := sum.latestVersion()
+
+	for p, hash := range sum {
+		fileHash, exists := files[p]
+		switch {
+		case !exists:
+			problems = append(problems, fmt.Sprintf("%s has been deleted", p))
+		case fileHash != hash:
+			problems = append(problems, fmt.Sprintf("%s has been changed", p))
+		}
+	}
+
+	for p := range files {
+		if _, exists := sum[p]; !exists && sumFileVersion(p) < latestVersion {
+			problems = append(problems, fmt.Sprintf("%s has been added before migration %d", p, latestVersion))
+		}
+	}
+
+	slices.Sort(problems)
+
+	return problems, true, nil
+}
+
+// latestVersion returns the version of the latest hashed migration.
+func (s Sum) latestVersion() uint {
+	var version uint
+	for p := range s {
+		if strings.HasPrefix(p, "migrations/") {
+			version = max(version, sumFileVersion(p))
+		}
+	}
+
+	return version
+}
+
+// sumFileVersion returns the version of the migration a file belongs to, 0 if it has none.
+func sumFileVersion(p string) uint {
+	version, err := GetMigrationVersion(path.Base(p))
+	if err != nil {
+		return 0
+	}
+
+	return version
+}
@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// FindTestdata returns the paths of the testdata files belonging to the given migration, including those in
+// subdirectories of the testdata directory, in lexical order. A testdata file belongs to the migration whose version its
+// name starts with, regardless of zero padding.
+func FindTestdata(wd string, migrationNumber uint) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(filepath.Join(wd, "testdata"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		if version, ok := leadingVersion(d.Name()); ok && version == migrationNumber {
+			files = append(files, path)
+		}
+
+		return nil
+	})
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read testdata directory: %w", err)
+	}
+
+	return files, nil
+}
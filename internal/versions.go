@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/printeers/trek/internal/configuration"
+)
+
+const (
+	defaultVersionDigits   = 3
+	timestampVersionLayout = "20060102150405"
+)
+
+var ErrUnknownVersion = errors.New("unknown version")
+
+// VersionScheme formats and validates the versions in the names of migration
+// and testdata files, see configuration.Versions.
+type VersionScheme struct {
+	timestamp bool
+	digits    uint
+}
+
+func NewVersionScheme(config *configuration.Config) VersionScheme {
+	scheme := VersionScheme{digits: defaultVersionDigits}
+	if config.Versions == nil {
+		return scheme
+	}
+
+	scheme.timestamp = config.Versions.Scheme == configuration.VersionSchemeTimestamp
+	if config.Versions.Digits > 0 {
+		scheme.digits = config.Versions.Digits
+	}
+
+	return scheme
+}
+
+// Timestamp reports whether versions are UTC timestamps.
+func (s VersionScheme) Timestamp() bool {
+	return s.timestamp
+}
+
+// FormatVersion returns the version as it is written in file names.
+func (s VersionScheme) FormatVersion(version uint) string {
+	if s.timestamp {
+		return strconv.FormatUint(uint64(version), 10)
+	}
+
+	return fmt.Sprintf("%0*d", s.digits, version)
+}
+
+// MigrationFileName returns the name of the up migration with the given version and name.
+func (s VersionScheme) MigrationFileName(version uint, migrationName string) string {
+	return fmt.Sprintf("%s_%s%s", s.FormatVersion(version), migrationName, upMigrationSuffix)
+}
+
+// TestdataFileName returns the name of a testdata file belonging to the given migration.
+func (s VersionScheme) TestdataFileName(version uint, name string) string {
+	return fmt.Sprintf("%s_%s.sql", s.FormatVersion(version), name)
+}
+
+// FirstVersion returns the version of the first migration of a project.
+func (s VersionScheme) FirstVersion() uint {
+	if s.timestamp {
+		return timestampVersion(time.Now())
+	}
+
+	return 1
+}
+
+// NextVersion returns the version that directly follows the given one. For
+// timestamps that is one second later.
+func (s VersionScheme) NextVersion(version uint) uint {
+	if !s.timestamp {
+		return version + 1
+	}
+
+	t, err := time.Parse(timestampVersionLayout, strconv.FormatUint(uint64(version), 10))
+	if err != nil {
+		// Versions from before the switch to timestamps are followed by the current time
+		return max(timestampVersion(time.Now()), version+1)
+	}
+
+	return timestampVersion(t.Add(time.Second))
+}
+
+// NewVersion returns the version of a new migration after the latest one. Pass 0 if there is no migration yet.
+func (s VersionScheme) NewVersion(latestVersion uint) uint {
+	if latestVersion == 0 {
+		return s.FirstVersion()
+	}
+
+	if s.timestamp {
+		return max(timestampVersion(time.Now()), s.NextVersion(latestVersion))
+	}
+
+	return latestVersion + 1
+}
+
+// ValidateMigrationFileNames checks the names of the sorted up migrations.
+// Sequential versions must start at 1 and have no gaps. Timestamp versions
+// must be valid timestamps, only versions from before a project switched to
+// timestamps may be shorter.
+func (s VersionScheme) ValidateMigrationFileNames(migrationFiles []string) error {
+	var previousVersion uint
+	for index, migrationFile := range migrationFiles {
+		if !RegexpMigrationFileName.MatchString(migrationFile) {
+			//nolint:err113
+			return fmt.Errorf("invalid migration file name %q", migrationFile)
+		}
+
+		version, err := GetMigrationVersion(migrationFile)
+		if err != nil {
+			return err
+		}
+
+		if index > 0 && version == previousVersion {
+			//nolint:err113
+			return fmt.Errorf("migration with version %s exists more than once", s.FormatVersion(version))
+		}
+
+		if s.timestamp {
+			prefix, _, _ := strings.Cut(migrationFile, "_")
+			if len(prefix) >= len(timestampVersionLayout) {
+				if _, err = time.Parse(timestampVersionLayout, prefix); err != nil {
+					//nolint:err113
+					return fmt.Errorf(
+						"version of migration file %q is not a timestamp formatted as YYYYMMDDHHMMSS",
+						migrationFile,
+					)
+				}
+			}
+		} else if version != uint(index)+1 { //nolint:gosec
+			//nolint:err113
+			return fmt.Errorf("migration after version %s missing", s.FormatVersion(previousVersion))
+		}
+
+		previousVersion = version
+	}
+
+	return nil
+}
+
+// LatestMigrationVersion returns the version of the last of the sorted up migrations, 0 if there are none.
+func LatestMigrationVersion(migrationFiles []string) (uint, error) {
+	if len(migrationFiles) == 0 {
+		return 0, nil
+	}
+
+	return GetMigrationVersion(migrationFiles[len(migrationFiles)-1])
+}
+
+// MigrationsUpTo returns the sorted up migrations up to and including the
+// given version, which must be the version of one of them. Version 0 returns
+// all migrations.
+func MigrationsUpTo(migrationFiles []string, version uint) ([]string, error) {
+	if version == 0 {
+		return migrationFiles, nil
+	}
+
+	for index, file := range migrationFiles {
+		fileVersion, err := GetMigrationVersion(file)
+		if err != nil {
+			return nil, err
+		}
+		if fileVersion == version {
+			return migrationFiles[:index+1], nil
+		}
+	}
+
+	latestVersion, err := LatestMigrationVersion(migrationFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, fmt.Errorf("%w: version %d does not exist, the latest migration is version %d",
+		ErrUnknownVersion,
+		version,
+		latestVersion,
+	)
+}
+
+// sortMigrationFiles sorts the file names by version. The names only sort the
+// same way while all versions have the same number of digits.
+func sortMigrationFiles(files []string) {
+	slices.SortStableFunc(files, func(a, b string) int {
+		versionA, _ := leadingVersion(a)
+		versionB, _ := leadingVersion(b)
+
+		return cmp.Or(cmp.Compare(versionA, versionB), strings.Compare(a, b))
+	})
+}
+
+// leadingVersion parses the digits at the start of a file name.
+func leadingVersion(fileName string) (uint, bool) {
+	end := strings.IndexFunc(fileName, func(r rune) bool { return r < '0' || r > '9' })
+	if end == -1 {
+		end = len(fileName)
+	}
+
+	version, err := strconv.ParseUint(fileName[:end], 10, 0)
+	if err != nil {
+		return 0, false
+	}
+
+	return uint(version), true
+}
+
+func timestampVersion(t time.Time) uint {
+	version, _ := strconv.ParseUint(t.UTC().Format(timestampVersionLayout), 10, 0)
+
+	return uint(version)
+}
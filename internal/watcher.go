@@ -0,0 +1,240 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Watcher reports changes to files using filesystem notifications. Events are
+// debounced and only files whose content actually changed are reported.
+type Watcher struct {
+	watcher       *fsnotify.Watcher
+	debounce      time.Duration
+	files         []string
+	dirs          []string
+	recursiveDirs []string
+	ignored       []string
+	hashes        map[string][sha256.Size]byte
+}
+
+// NewWatcher watches the given files, every file in the given directories and
+// every file in the recursive directories and their subdirectories, including
+// subdirectories created later. Files are watched through their parent
+// directory so that editors replacing a file on save are noticed. Directories
+// that don't exist are watched once they are created. Paths in ignored are
+// never reported.
+func NewWatcher(files, dirs, recursiveDirs, ignored []string, debounce time.Duration) (*Watcher, error) {
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create watcher: %w", err)
+	}
+
+	w := &Watcher{
+		watcher:       fsWatcher,
+		debounce:      debounce,
+		files:         files,
+		dirs:          dirs,
+		recursiveDirs: recursiveDirs,
+		ignored:       ignored,
+		hashes:        map[string][sha256.Size]byte{},
+	}
+
+	var watchDirs []string
+	for _, dir := range slices.Concat(dirs, recursiveDirs) {
+		if _, err = os.Stat(dir); err == nil {
+			watchDirs = append(watchDirs, dir)
+		} else {
+			// Watch the parent, so that the directory is noticed when it is created
+			watchDirs = append(watchDirs, filepath.Dir(dir))
+		}
+	}
+	for _, file := range files {
+		watchDirs = append(watchDirs, filepath.Dir(file))
+	}
+	slices.Sort(watchDirs)
+
+	paths := slices.Clone(files)
+	for _, dir := range slices.Compact(watchDirs) {
+		var dirPaths []string
+		dirPaths, err = w.addDir(dir)
+		if err != nil {
+			_ = fsWatcher.Close()
+
+			return nil, err
+		}
+		paths = append(paths, dirPaths...)
+	}
+
+	for _, path := range paths {
+		if w.isWatched(path) {
+			w.changed(path)
+		}
+	}
+
+	return w, nil
+}
+
+func (w *Watcher) Close() error {
+	//nolint:wrapcheck
+	return w.watcher.Close()
+}
+
+// Wait blocks until at least one watched file changed and returns the changed files, sorted by name.
+func (w *Watcher) Wait(ctx context.Context) ([]string, error) {
+	pending := map[string]struct{}{}
+	var timer <-chan time.Time
+
+	for {
+		select {
+		case <-ctx.Done():
+			//nolint:wrapcheck
+			return nil, ctx.Err()
+		case err := <-w.watcher.Errors:
+			return nil, fmt.Errorf("failed to watch files: %w", err)
+		case event := <-w.watcher.Events:
+			if event.Has(fsnotify.Create) && w.isWatchedDir(event.Name) {
+				paths, err := w.addDir(event.Name)
+				if err != nil {
+					return nil, err
+				}
+				// Files may have been created before the directory was watched
+				for _, path := range paths {
+					if w.isWatched(path) {
+						pending[path] = struct{}{}
+					}
+				}
+				timer = time.After(w.debounce)
+			} else if w.isWatched(event.Name) {
+				pending[event.Name] = struct{}{}
+				timer = time.After(w.debounce)
+			}
+		case <-timer:
+			timer = nil
+
+			var changed []string
+			for path := range pending {
+				if w.changed(path) {
+					changed = append(changed, path)
+				}
+			}
+			pending = map[string]struct{}{}
+
+			if len(changed) > 0 {
+				sort.Strings(changed)
+
+				return changed, nil
+			}
+		}
+	}
+}
+
+func (w *Watcher) isWatched(path string) bool {
+	if slices.Contains(w.ignored, path) {
+		return false
+	}
+
+	return slices.Contains(w.files, path) || slices.Contains(w.dirs, filepath.Dir(path)) || w.inRecursiveDir(path)
+}
+
+// isWatchedDir reports whether the path is one of the watched directories or a
+// directory in one of the recursive directories.
+func (w *Watcher) isWatchedDir(path string) bool {
+	if slices.Contains(w.dirs, path) || slices.Contains(w.recursiveDirs, path) {
+		return true
+	}
+
+	info, err := os.Stat(path)
+
+	return err == nil && info.IsDir() && w.inRecursiveDir(path)
+}
+
+// inRecursiveDir reports whether the path is one of the recursive directories or is inside one of them.
+func (w *Watcher) inRecursiveDir(path string) bool {
+	return slices.ContainsFunc(w.recursiveDirs, func(dir string) bool {
+		rel, err := filepath.Rel(dir, path)
+
+		return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+	})
+}
+
+// addDir watches the directory and returns the files currently in it. In a
+// recursive directory, its subdirectories are watched as well and their files
+// are returned too.
+func (w *Watcher) addDir(dir string) ([]string, error) {
+	if !w.inRecursiveDir(dir) {
+		err := w.watcher.Add(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to watch %q: %w", dir, err)
+		}
+
+		return w.dirFiles(dir), nil
+	}
+
+	var paths []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			paths = append(paths, path)
+
+			return nil
+		}
+
+		err = w.watcher.Add(path)
+		if err != nil {
+			return fmt.Errorf("failed to watch %q: %w", path, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	return paths, nil
+}
+
+// dirFiles returns the files currently in the directory.
+func (w *Watcher) dirFiles(dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			paths = append(paths, filepath.Join(dir, entry.Name()))
+		}
+	}
+
+	return paths
+}
+
+// changed updates the stored hash of the file and reports whether it differs from the previous one.
+func (w *Watcher) changed(path string) bool {
+	previous, existed := w.hashes[path]
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		delete(w.hashes, path)
+
+		return existed
+	}
+
+	hash := sha256.Sum256(content)
+	w.hashes[path] = hash
+
+	return !existed || hash != previous
+}